@@ -40,6 +40,9 @@ type RWExternalStatus struct {
 	// Conditions
 	Conditions condition.Conditions `json:"conditions,omitempty" optional:"true"`
 
+	// ObservedGeneration is the generation of the Spec the Status reflects
+	ObservedGeneration int64 `json:"observedGeneration,omitempty" optional:"true"`
+
 	// OutputSecret provides the name of the Secret where the result of the
 	// processing stored
 	OutputSecret *string `json:"outputSecret,omitempty" optional:"true"`
@@ -77,3 +80,11 @@ func (i RWExternal) GetConditions() condition.Conditions {
 func (i *RWExternal) SetConditions(conditions condition.Conditions) {
 	i.Status.Conditions = conditions
 }
+
+func (i RWExternal) GetObservedGeneration() int64 {
+	return i.Status.ObservedGeneration
+}
+
+func (i *RWExternal) SetObservedGeneration(generation int64) {
+	i.Status.ObservedGeneration = generation
+}
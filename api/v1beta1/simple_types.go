@@ -40,6 +40,9 @@ type SimpleStatus struct {
 	// Conditions
 	Conditions condition.Conditions `json:"conditions,omitempty" optional:"true"`
 
+	// ObservedGeneration is the generation of the Spec the Status reflects
+	ObservedGeneration int64 `json:"observedGeneration,omitempty" optional:"true"`
+
 	// Quotient
 	Quotient *int `json:"quotient,omitempty" optional:"true"`
 
@@ -79,3 +82,11 @@ func (i Simple) GetConditions() condition.Conditions {
 func (i *Simple) SetConditions(conditions condition.Conditions) {
 	i.Status.Conditions = conditions
 }
+
+func (i Simple) GetObservedGeneration() int64 {
+	return i.Status.ObservedGeneration
+}
+
+func (i *Simple) SetObservedGeneration(generation int64) {
+	i.Status.ObservedGeneration = generation
+}
@@ -30,6 +30,7 @@ import (
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/record"
 	ctrl "sigs.k8s.io/controller-runtime"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
@@ -46,11 +47,13 @@ import (
 // RWExternalReconciler reconciles a RWExternal object
 type RWExternalReconciler struct {
 	client.Client
-	Scheme *runtime.Scheme
+	Scheme   *runtime.Scheme
+	recorder record.EventRecorder
+	handler  *reconcile.Handler[*v1beta1.RWExternal, *RWExternalRReq]
 }
 
 type RWExternalRReq struct {
-	reconcile.Req[*v1beta1.RWExternal]
+	*reconcile.DefaultReq[*v1beta1.RWExternal]
 	Dividend     *int
 	Divisor      *int
 	OutputSecret *corev1.Secret
@@ -59,6 +62,7 @@ type RWExternalRReq struct {
 //+kubebuilder:rbac:groups=okofw-example.openstack.org,resources=rwexternals,verbs=get;list;watch;create;update;patch;delete
 //+kubebuilder:rbac:groups=okofw-example.openstack.org,resources=rwexternals/status,verbs=get;update;patch
 //+kubebuilder:rbac:groups=okofw-example.openstack.org,resources=rwexternals/finalizers,verbs=update
+//+kubebuilder:rbac:groups="",resources=events,verbs=create;patch
 
 // Reconcile is part of the main kubernetes reconciliation loop which aims to
 // move the current state of the cluster closer to the desired state.
@@ -67,11 +71,12 @@ type RWExternalRReq struct {
 // - https://pkg.go.dev/sigs.k8s.io/controller-runtime@v0.12.2/pkg/reconcile
 func (r *RWExternalReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
 	rReq := &RWExternalRReq{
-		Req: &reconcile.DefaultReq[*v1beta1.RWExternal]{
+		DefaultReq: &reconcile.DefaultReq[*v1beta1.RWExternal]{
 			Ctx:            ctx,
 			Request:        req,
 			Log:            log.FromContext(ctx),
 			Client:         r.Client,
+			Recorder:       r.recorder,
 			Instance:       &v1beta1.RWExternal{},
 			RequeueTimeout: time.Duration(1) * time.Second,
 		},
@@ -84,17 +89,26 @@ func (r *RWExternalReconciler) Reconcile(ctx context.Context, req ctrl.Request)
 			},
 		},
 	}
-	return reconcile.NewReqHandler[*v1beta1.RWExternal, *RWExternalRReq]().
+	return r.handler.Handle(rReq)
+}
+
+// SetupWithManager sets up the controller with the Manager.
+func (r *RWExternalReconciler) SetupWithManager(mgr ctrl.Manager) error {
+	handler, err := reconcile.NewReqHandler[*v1beta1.RWExternal, *RWExternalRReq]().
 		WithSteps(
 			&steps.Conditions[*v1beta1.RWExternal, *RWExternalRReq]{},
+			&steps.ObservedGeneration[*v1beta1.RWExternal, *RWExternalRReq]{},
 			EnsureInput{},
 			DivideAndStore{},
 		).
-		Handle(rReq)
-}
+		WithBackoff(time.Second, 30*time.Second).
+		Build()
+	if err != nil {
+		return err
+	}
+	r.handler = handler
+	r.recorder = mgr.GetEventRecorderFor("rwexternal-controller")
 
-// SetupWithManager sets up the controller with the Manager.
-func (r *RWExternalReconciler) SetupWithManager(mgr ctrl.Manager) error {
 	return ctrl.NewControllerManagedBy(mgr).
 		For(&v1beta1.RWExternal{}).
 		Complete(r)
@@ -132,7 +146,8 @@ func (s EnsureInput) Do(r *RWExternalRReq, log logr.Logger) reconcile.Result {
 				condition.RequestedReason,
 				condition.SeverityInfo,
 				"Missing input: secret/"+secretName.Name))
-			return r.RequeueAfter("Waiting for input secret/"+secretName.Name, nil)
+			return r.RequeueWithBackoff(
+				"Waiting for input secret/"+secretName.Name, secretName.Name)
 		}
 		err = fmt.Errorf("failed to read/secret/%s:%w", secretName.Name, err)
 		r.GetInstance().Status.Conditions.Set(condition.FalseCondition(
@@ -200,6 +215,11 @@ func (s DivideAndStore) GetManagedConditions() condition.Conditions {
 	}
 }
 
+func (s DivideAndStore) GetDependencies() []reconcile.Dependency {
+	// we need the input parsed before we can divide
+	return []reconcile.Dependency{reconcile.OnStep("EnsureInput")}
+}
+
 func (s DivideAndStore) Do(r *RWExternalRReq, log logr.Logger) reconcile.Result {
 	if *r.Divisor == 0 {
 		err := fmt.Errorf("division by zero")
@@ -212,7 +232,7 @@ func (s DivideAndStore) Do(r *RWExternalRReq, log logr.Logger) reconcile.Result
 		return r.Error(err, log)
 	}
 
-	_, err := controllerutil.CreateOrPatch(r.GetCtx(), r.GetClient(), r.OutputSecret, func() error {
+	op, err := controllerutil.CreateOrPatch(r.GetCtx(), r.GetClient(), r.OutputSecret, func() error {
 		r.OutputSecret.Data = map[string][]byte{
 			"quotient":  []byte(fmt.Sprint(*r.Dividend / *r.Divisor)),
 			"remainder": []byte(fmt.Sprint(*r.Dividend % *r.Divisor)),
@@ -237,11 +257,23 @@ func (s DivideAndStore) Do(r *RWExternalRReq, log logr.Logger) reconcile.Result
 		return r.Error(err, log)
 	}
 
+	if op != controllerutil.OperationResultNone {
+		r.GetEventRecorder().Eventf(
+			r.GetInstance(), corev1.EventTypeNormal, "OutputStored",
+			"Output secret/%s is %s", r.OutputSecret.Name, op)
+	}
+
 	r.GetInstance().Status.OutputSecret = &r.OutputSecret.Name
 	r.GetInstance().Status.Conditions.MarkTrue(v1beta1.OutputReadyCondition, v1beta1.OutputReadyReadyMessage)
 	return r.OK()
 }
 
+// GetOrphanableResources allows keeping the output Secret if the CR is
+// deleted with the Orphan deletion policy
+func (s DivideAndStore) GetOrphanableResources(r *RWExternalRReq) []string {
+	return []string{"secret/" + r.OutputSecret.Name}
+}
+
 func (s DivideAndStore) Cleanup(r *RWExternalRReq, log logr.Logger) reconcile.Result {
 	err := r.GetClient().Delete(r.GetCtx(), r.OutputSecret)
 	if k8s_errors.IsNotFound(err) {
@@ -2,11 +2,13 @@ package controllers
 
 import (
 	"github.com/gibizer/okofw/api/v1beta1"
+	"github.com/gibizer/okofw/pkg/reconcile"
 	"github.com/google/uuid"
 	. "github.com/onsi/ginkgo/v2"
 	. "github.com/onsi/gomega"
 	"github.com/openstack-k8s-operators/lib-common/modules/common/condition"
 	corev1 "k8s.io/api/core/v1"
+	k8s_errors "k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/types"
 )
@@ -241,7 +243,7 @@ var _ = Describe("RWExternal controller", func() {
 			g.Expect(rw.Status.OutputSecret).NotTo(BeNil())
 			th.GetSecret(types.NamespacedName{Namespace: namespace, Name: *rw.Status.OutputSecret})
 
-			g.Expect(rw.Finalizers).To(ContainElement("RWExternal"))
+			g.Expect(rw.Finalizers).To(ContainElement("okofw-example.openstack.org/rwexternal-finalizer"))
 
 		}, timeout, interval).Should(Succeed())
 
@@ -254,4 +256,39 @@ var _ = Describe("RWExternal controller", func() {
 
 	})
 
+	It("Keeps the output secret when RWExternal is deleted with Orphan policy", func() {
+		secretName := types.NamespacedName{Namespace: namespace, Name: "input"}
+		th.CreateSecret(secretName, map[string][]byte{
+			"dividend": []byte("10"),
+			"divisor":  []byte("5"),
+		})
+		DeferCleanup(DeleteInstance, secretName)
+
+		rwName := CreateRWExternal(namespace, v1beta1.RWExternalSpec{InputSecret: "input"})
+		DeferCleanup(DeleteInstance, rwName)
+
+		Eventually(func(g Gomega) {
+			rw := GetRWExternal(rwName)
+			g.Expect(rw.Status.OutputSecret).NotTo(BeNil())
+		}, timeout, interval).Should(Succeed())
+
+		Eventually(func(g Gomega) {
+			rw := GetRWExternal(rwName)
+			rw.Annotations = map[string]string{
+				reconcile.DeletionPolicyAnnotation: string(reconcile.DeletionPolicyOrphan),
+			}
+			g.Expect(k8sClient.Update(ctx, rw)).Should(Succeed())
+		}, timeout, interval).Should(Succeed())
+
+		rw := GetRWExternal(rwName)
+		th.DeleteInstance(rw)
+
+		Eventually(func(g Gomega) {
+			err := k8sClient.Get(ctx, rwName, &v1beta1.RWExternal{})
+			g.Expect(k8s_errors.IsNotFound(err)).To(BeTrue())
+		}, timeout, interval).Should(Succeed())
+
+		th.GetSecret(types.NamespacedName{Namespace: namespace, Name: *rw.Status.OutputSecret})
+	})
+
 })
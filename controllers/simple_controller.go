@@ -21,6 +21,7 @@ import (
 	"fmt"
 
 	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/tools/record"
 	ctrl "sigs.k8s.io/controller-runtime"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 	"sigs.k8s.io/controller-runtime/pkg/log"
@@ -35,7 +36,9 @@ import (
 // SimpleReconciler reconciles a Simple object
 type SimpleReconciler struct {
 	client.Client
-	Scheme *runtime.Scheme
+	Scheme   *runtime.Scheme
+	recorder record.EventRecorder
+	handler  *reconcile.Handler[*v1beta1.Simple, *SimpleRReq]
 }
 
 type SimpleRReq struct {
@@ -45,6 +48,7 @@ type SimpleRReq struct {
 //+kubebuilder:rbac:groups=okofw-example.openstack.org,resources=simples,verbs=get;list;watch;create;update;patch;delete
 //+kubebuilder:rbac:groups=okofw-example.openstack.org,resources=simples/status,verbs=get;update;patch
 //+kubebuilder:rbac:groups=okofw-example.openstack.org,resources=simples/finalizers,verbs=update
+//+kubebuilder:rbac:groups="",resources=events,verbs=create;patch
 
 // Reconcile is part of the main kubernetes reconciliation loop which aims to
 // move the current state of the cluster closer to the desired state.
@@ -56,21 +60,30 @@ func (r *SimpleReconciler) Reconcile(ctx context.Context, req ctrl.Request) (res
 			Request:  req,
 			Log:      log.FromContext(ctx),
 			Client:   r.Client,
+			Recorder: r.recorder,
 			Instance: &v1beta1.Simple{},
 		},
 	}
 
-	return reconcile.NewReqHandler[*v1beta1.Simple, *SimpleRReq]().
+	return r.handler.Handle(rReq)
+}
+
+// SetupWithManager sets up the controller with the Manager.
+func (r *SimpleReconciler) SetupWithManager(mgr ctrl.Manager) error {
+	handler, err := reconcile.NewReqHandler[*v1beta1.Simple, *SimpleRReq]().
 		WithSteps(
 			&steps.Conditions[*v1beta1.Simple, *SimpleRReq]{},
+			&steps.ObservedGeneration[*v1beta1.Simple, *SimpleRReq]{},
 			EnsureNonZeroDivisor{},
 			Divide{},
 		).
-		Handle(rReq)
-}
+		Build()
+	if err != nil {
+		return err
+	}
+	r.handler = handler
+	r.recorder = mgr.GetEventRecorderFor("simple-controller")
 
-// SetupWithManager sets up the controller with the Manager.
-func (r *SimpleReconciler) SetupWithManager(mgr ctrl.Manager) error {
 	return ctrl.NewControllerManagedBy(mgr).
 		For(&v1beta1.Simple{}).
 		Complete(r)
@@ -99,7 +112,8 @@ func (s EnsureNonZeroDivisor) Do(r *SimpleRReq, log logr.Logger) reconcile.Resul
 		err := fmt.Errorf("division by zero")
 		r.GetInstance().Status.Conditions.MarkFalse(
 			condition.InputReadyCondition, condition.ErrorReason, condition.SeverityError, err.Error())
-		return r.Error(err, log)
+		// retrying does not help until the spec is fixed
+		return r.Terminal(err)
 	}
 	return r.OK()
 }
@@ -122,6 +136,12 @@ func (s Divide) GetManagedConditions() condition.Conditions {
 	}
 }
 
+func (s Divide) GetDependencies() []reconcile.Dependency {
+	return []reconcile.Dependency{
+		reconcile.OnStepType[EnsureNonZeroDivisor](),
+	}
+}
+
 func (s Divide) Do(r *SimpleRReq, log logr.Logger) reconcile.Result {
 	instance := r.GetInstance()
 	quotient := instance.Spec.Dividend / instance.Spec.Divisor
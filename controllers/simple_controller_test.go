@@ -2,6 +2,7 @@ package controllers
 
 import (
 	v1beta1 "github.com/gibizer/okofw/api/v1beta1"
+	"github.com/gibizer/okofw/pkg/reconcile"
 	"github.com/google/uuid"
 	. "github.com/onsi/ginkgo/v2"
 	. "github.com/onsi/gomega"
@@ -81,6 +82,25 @@ var _ = Describe("Simple controller", func() {
 		simple := GetSimple(simpleName)
 		Expect(*simple.Status.Quotient).To(Equal(2))
 		Expect(*simple.Status.Remainder).To(Equal(0))
+		Expect(simple.Status.ObservedGeneration).To(Equal(simple.Generation))
+	})
+	It("Traces the reconciliation", func() {
+		simpleName := CreateSimple(namespace, v1beta1.SimpleSpec{Dividend: 10, Divisor: 5})
+		DeferCleanup(DeleteInstance, simpleName)
+
+		ExpectSimpleStatusReady(simpleName)
+
+		Eventually(func(g Gomega) {
+			names := []string{}
+			for _, span := range traceExporter.GetSpans() {
+				for _, attr := range span.Attributes {
+					if attr == reconcile.NameAttribute.String(simpleName.Name) {
+						names = append(names, span.Name)
+					}
+				}
+			}
+			g.Expect(names).To(ContainElement("Reconcile"))
+		}, timeout, interval).Should(Succeed())
 	})
 	It("Fails to divide with zero", func() {
 		simpleName := CreateSimple(namespace, v1beta1.SimpleSpec{Dividend: 10, Divisor: 0})
@@ -27,6 +27,9 @@ import (
 	. "github.com/onsi/gomega"
 
 	"github.com/openstack-k8s-operators/lib-common/modules/test/helpers"
+	"go.opentelemetry.io/otel"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
 	corev1 "k8s.io/api/core/v1"
 	k8s_errors "k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
@@ -60,6 +63,8 @@ var (
 	cancel    context.CancelFunc
 	logger    logr.Logger
 	th        *helpers.TestHelper
+	// collects the spans of the reconciliations
+	traceExporter *tracetest.InMemoryExporter
 )
 
 func TestAPIs(t *testing.T) {
@@ -72,6 +77,9 @@ var _ = BeforeSuite(func() {
 
 	ctx, cancel = context.WithCancel(context.TODO())
 
+	traceExporter = tracetest.NewInMemoryExporter()
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSyncer(traceExporter)))
+
 	By("bootstrapping test environment")
 	testEnv = &envtest.Environment{
 		CRDDirectoryPaths:     []string{filepath.Join("..", "config", "crd", "bases")},
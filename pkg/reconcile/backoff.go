@@ -0,0 +1,85 @@
+package reconcile
+
+import (
+	"fmt"
+	"time"
+
+	"k8s.io/apimachinery/pkg/types"
+	ctrl "sigs.k8s.io/controller-runtime"
+)
+
+const (
+	// DefaultBackoffBase is the delay of the first requeue requested via
+	// RequeueWithBackoff
+	DefaultBackoffBase = 1 * time.Second
+	// DefaultBackoffMax is the maximum delay of a requeue requested via
+	// RequeueWithBackoff
+	DefaultBackoffMax = 5 * time.Minute
+)
+
+// backoffID identifies the attempts of a step of an instance waiting for
+// something
+type backoffID struct {
+	phase string
+	step  string
+	key   string
+}
+
+// backoff tracks the attempts of the steps requesting a requeue via
+// RequeueWithBackoff per instance and calculates the exponentially growing
+// delay of the requeue.
+type backoff struct {
+	*instanceStore[backoffID, int]
+	base time.Duration
+	max  time.Duration
+}
+
+func newBackoff(base time.Duration, max time.Duration) *backoff {
+	return &backoff{instanceStore: newInstanceStore[backoffID, int](), base: base, max: max}
+}
+
+// apply calculates the delay of the result if it requests a requeue with
+// backoff, or resets the attempts of the step if the result is OK.
+func (b *backoff) apply(
+	instance types.NamespacedName, phase string, step string, result Result,
+) Result {
+	if result.IsOK() {
+		b.reset(instance, phase, step)
+		return result
+	}
+	key := result.GetBackoffKey()
+	if key == "" || result.IsError() {
+		return result
+	}
+
+	attempt, delay := b.next(instance, backoffID{phase: phase, step: step, key: key})
+	return DefaultResult{
+		Result:     ctrl.Result{Requeue: true, RequeueAfter: delay},
+		requeueMsg: fmt.Sprintf("%s (attempt %d)", requeueMsg(result), attempt),
+		backoffKey: key,
+	}
+}
+
+// next records a new attempt of the instance and returns the number of
+// attempts so far and the delay before the next one
+func (b *backoff) next(instance types.NamespacedName, id backoffID) (int, time.Duration) {
+	attempt := b.update(instance, id, func(attempt int, _ bool) int {
+		return attempt + 1
+	})
+
+	delay := b.base
+	for i := 1; i < attempt && delay < b.max; i++ {
+		delay *= 2
+	}
+	if delay > b.max {
+		delay = b.max
+	}
+	return attempt, delay
+}
+
+// reset forgets the attempts of the step of the instance in the phase
+func (b *backoff) reset(instance types.NamespacedName, phase string, step string) {
+	b.deleteIf(instance, func(id backoffID) bool {
+		return id.phase == phase && id.step == step
+	})
+}
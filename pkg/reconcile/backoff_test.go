@@ -0,0 +1,86 @@
+package reconcile
+
+import (
+	"testing"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+
+	. "github.com/onsi/gomega"
+)
+
+func TestRequeueWithBackoff(t *testing.T) {
+	g := NewWithT(t)
+	c := newTestClient(newTestInstance())
+	ready := false
+	handler, err := NewReqHandler[*corev1.Pod, *TestReq]().
+		WithSteps(FuncStep{name: "step1", do: func(r *TestReq) Result {
+			if !ready {
+				return r.RequeueWithBackoff("waiting for input", "input")
+			}
+			return r.OK()
+		}}).
+		WithBackoff(time.Second, 5*time.Second).
+		Build()
+	g.Expect(err).NotTo(HaveOccurred())
+
+	expectRequeueAfter := func(delay time.Duration, attempt string) {
+		result := handler.handleReqWithReport(newTestReq(c), &Report{})
+		res, err := result.Unwrap()
+		g.Expect(err).NotTo(HaveOccurred())
+		g.Expect(res.RequeueAfter).To(Equal(delay))
+		g.Expect(result.String()).To(ContainSubstring(attempt))
+	}
+
+	expectRequeueAfter(1*time.Second, "waiting for input (attempt 1)")
+	expectRequeueAfter(2*time.Second, "(attempt 2)")
+	expectRequeueAfter(4*time.Second, "(attempt 3)")
+	expectRequeueAfter(5*time.Second, "(attempt 4)")
+	expectRequeueAfter(5*time.Second, "(attempt 5)")
+
+	ready = true
+	g.Expect(handler.handleReqWithReport(newTestReq(c), &Report{}).IsOK()).To(BeTrue())
+
+	// success resets the backoff
+	ready = false
+	expectRequeueAfter(1*time.Second, "(attempt 1)")
+}
+
+func TestBackoffTrackedPerInstanceStepAndKey(t *testing.T) {
+	g := NewWithT(t)
+	b := newBackoff(time.Second, time.Minute)
+	pod1 := testInstanceName
+	pod2 := testInstanceName
+	pod2.Name = "other"
+	id := backoffID{phase: "Do", step: "step1", key: "a"}
+
+	_, delay := b.next(pod1, id)
+	g.Expect(delay).To(Equal(time.Second))
+	_, delay = b.next(pod1, id)
+	g.Expect(delay).To(Equal(2 * time.Second))
+
+	attempt, delay := b.next(pod2, id)
+	g.Expect(attempt).To(Equal(1))
+	g.Expect(delay).To(Equal(time.Second))
+	for _, other := range []backoffID{
+		{phase: "Post", step: "step1", key: "a"},
+		{phase: "Do", step: "step2", key: "a"},
+		{phase: "Do", step: "step1", key: "b"},
+	} {
+		attempt, delay := b.next(pod1, other)
+		g.Expect(attempt).To(Equal(1))
+		g.Expect(delay).To(Equal(time.Second))
+	}
+
+	b.reset(pod1, "Do", "step1")
+	attempt, _ = b.next(pod1, id)
+	g.Expect(attempt).To(Equal(1))
+	attempt, _ = b.next(pod1, backoffID{phase: "Post", step: "step1", key: "a"})
+	g.Expect(attempt).To(Equal(2))
+
+	b.forget(pod1)
+	attempt, _ = b.next(pod1, id)
+	g.Expect(attempt).To(Equal(1))
+	attempt, _ = b.next(pod2, id)
+	g.Expect(attempt).To(Equal(2))
+}
@@ -0,0 +1,88 @@
+package reconcile
+
+import (
+	"github.com/openstack-k8s-operators/lib-common/modules/common/condition"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// ReconcileSucceededCondition is set to False by the Handler on instances
+// with conditions if the reconciliation failed in a way the steps could not
+// report themselves, e.g. a step panicked, or to list the failed steps if
+// the Handler continued after a failure. It is removed when the
+// reconciliation succeeds again. The Ready condition calculated by the
+// steps.Conditions step mirrors it.
+const ReconcileSucceededCondition condition.Type = "ReconcileSucceeded"
+
+// ReconcileActiveCondition is set to False by the Handler on instances with
+// conditions while the reconciliation is paused via the PausedAnnotation.
+// It is removed when the reconciliation resumes. The Ready condition
+// calculated by the steps.Conditions step mirrors it.
+const ReconcileActiveCondition condition.Type = "ReconcileActive"
+
+// ReconcilePausedReason is the reason of the ReconcileActiveCondition while
+// the reconciliation is paused
+const ReconcilePausedReason condition.Reason = "Paused"
+
+// instanceWithConditions is the same as steps.InstanceWithConditions but
+// defined here too to avoid an import cycle
+type instanceWithConditions interface {
+	GetConditions() condition.Conditions
+	SetConditions(condition.Conditions)
+}
+
+// markReconcileFailed sets the ReconcileSucceededCondition to False if the
+// instance has conditions already initialized.
+func markReconcileFailed[T client.Object](instance T, snapshot T, msg string) {
+	setCondition(instance, snapshot, condition.FalseCondition(
+		ReconcileSucceededCondition, condition.ErrorReason, condition.SeverityError,
+		"%s", msg))
+}
+
+// clearReconcileFailed removes the ReconcileSucceededCondition from the instance
+func clearReconcileFailed[T client.Object](instance T) {
+	removeCondition(instance, ReconcileSucceededCondition)
+}
+
+// markReconcilePaused sets the ReconcileActiveCondition to False if the
+// instance has conditions already initialized.
+func markReconcilePaused[T client.Object](instance T, snapshot T) {
+	setCondition(instance, snapshot, condition.FalseCondition(
+		ReconcileActiveCondition, ReconcilePausedReason, condition.SeverityInfo,
+		"Reconciliation is paused via the %s annotation", PausedAnnotation))
+}
+
+// clearReconcilePaused removes the ReconcileActiveCondition from the
+// instance
+func clearReconcilePaused[T client.Object](instance T) {
+	removeCondition(instance, ReconcileActiveCondition)
+}
+
+// setCondition sets the condition on the instance if the instance has
+// conditions already initialized. If the snapshot has the same condition
+// then it is kept as is so its LastTransitionTime does not change.
+func setCondition[T client.Object](instance T, snapshot T, cond *condition.Condition) {
+	obj, ok := any(instance).(instanceWithConditions)
+	if !ok || obj.GetConditions() == nil {
+		return
+	}
+	oldConditions := any(snapshot).(instanceWithConditions).GetConditions()
+	if old := oldConditions.Get(cond.Type); old != nil &&
+		old.Status == cond.Status && old.Reason == cond.Reason &&
+		old.Severity == cond.Severity && old.Message == cond.Message {
+		cond = old
+	}
+	conditions := obj.GetConditions()
+	conditions.Set(cond)
+	obj.SetConditions(conditions)
+}
+
+// removeCondition removes the condition from the instance
+func removeCondition[T client.Object](instance T, t condition.Type) {
+	obj, ok := any(instance).(instanceWithConditions)
+	if !ok || obj.GetConditions() == nil {
+		return
+	}
+	conditions := obj.GetConditions()
+	conditions.Remove(t)
+	obj.SetConditions(conditions)
+}
@@ -0,0 +1,229 @@
+package reconcile
+
+import (
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/go-logr/logr"
+	"github.com/openstack-k8s-operators/lib-common/modules/common/condition"
+	corev1 "k8s.io/api/core/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+const (
+	// AppliedStepsAnnotation lists the conditional steps that ran on the
+	// instance so their Cleanup can be run when they become not applicable
+	AppliedStepsAnnotation = "okofw.openstack.org/applied-steps"
+	// NotApplicableReason is the reason of the conditions managed by a
+	// conditional step that is skipped as it is not applicable
+	NotApplicableReason condition.Reason = "NotApplicable"
+)
+
+// When returns a Step that only runs the Do and Post of the given step if
+// the predicate is true for the request. When the predicate is false the
+// step is skipped, the conditions it manages are set to True with
+// NotApplicableReason, and if the step ran before then its Cleanup is run
+// once. Whether the step ran is tracked in the AppliedStepsAnnotation of
+// the instance.
+func When[T client.Object, R Req[T]](predicate func(r R) bool, step Step[T, R]) Step[T, R] {
+	return &conditionalStep[T, R]{step: step, predicate: predicate}
+}
+
+// Unless returns a Step that only runs the given step if the predicate is
+// false for the request. See When() for details.
+func Unless[T client.Object, R Req[T]](predicate func(r R) bool, step Step[T, R]) Step[T, R] {
+	return When(func(r R) bool { return !predicate(r) }, step)
+}
+
+type conditionalStep[T client.Object, R Req[T]] struct {
+	step      Step[T, R]
+	predicate func(r R) bool
+}
+
+func (s *conditionalStep[T, R]) unwrapStep() any {
+	return s.step
+}
+
+func (s *conditionalStep[T, R]) GetName() string {
+	return s.step.GetName()
+}
+
+func (s *conditionalStep[T, R]) Setup(steps []Step[T, R], log logr.Logger) error {
+	return s.step.Setup(steps, log)
+}
+
+func (s *conditionalStep[T, R]) Do(r R, log logr.Logger) Result {
+	if s.predicate(r) {
+		s.setApplied(r, true)
+		return s.step.Do(r, log)
+	}
+
+	log.V(1).Info("Skipped as not applicable")
+	s.markNotApplicable(r)
+	if !s.isApplied(r) {
+		return r.OK()
+	}
+	stepF, ok := cleanupF(s.step)
+	if !ok {
+		s.setApplied(r, false)
+		return r.OK()
+	}
+	log.Info("Cleaning up as the step was applied before")
+	result := stepF(r, log)
+	if result.IsOK() {
+		s.setApplied(r, false)
+	}
+	return result
+}
+
+func (s *conditionalStep[T, R]) Cleanup(r R, log logr.Logger) Result {
+	stepF, ok := cleanupF(s.step)
+	if !ok || (!s.isApplied(r) && !s.predicate(r)) {
+		return r.OK()
+	}
+	return stepF(r, log)
+}
+
+func (s *conditionalStep[T, R]) Post(r R, log logr.Logger) Result {
+	stepF, ok := postF(s.step)
+	if !ok || !s.predicate(r) {
+		return r.OK()
+	}
+	return stepF(r, log)
+}
+
+func (s *conditionalStep[T, R]) Init(r R, log logr.Logger) Result {
+	stepF, ok := initF[T, R](s.step)
+	if !ok || !s.predicate(r) {
+		return r.OK()
+	}
+	return stepF(r, log)
+}
+
+func (s *conditionalStep[T, R]) PreDelete(r R, log logr.Logger) Result {
+	stepF, ok := preDeleteF[T, R](s.step)
+	if !ok || (!s.isApplied(r) && !s.predicate(r)) {
+		return r.OK()
+	}
+	return stepF(r, log)
+}
+
+func (s *conditionalStep[T, R]) Finalize(r R, log logr.Logger) Result {
+	stepF, ok := finalizeF[T, R](s.step)
+	if !ok || (!s.isApplied(r) && !s.predicate(r)) {
+		return r.OK()
+	}
+	return stepF(r, log)
+}
+
+// GetManagedConditions returns the conditions managed by the wrapped step
+// so the condition handling works the same as without the wrapper.
+func (s *conditionalStep[T, R]) GetManagedConditions() condition.Conditions {
+	if mgr, ok := s.step.(interface {
+		GetManagedConditions() condition.Conditions
+	}); ok {
+		return mgr.GetManagedConditions()
+	}
+	return nil
+}
+
+func (s *conditionalStep[T, R]) GetDependencies() []Dependency {
+	if step, ok := s.step.(StepWithDependencies); ok {
+		return step.GetDependencies()
+	}
+	return nil
+}
+
+func (s *conditionalStep[T, R]) GetTimeout() time.Duration {
+	return getTimeout[T, R](s.step)
+}
+
+func (s *conditionalStep[T, R]) ContinueOnError() bool {
+	step, ok := s.step.(StepWithContinueOnError)
+	return ok && step.ContinueOnError()
+}
+
+func (s *conditionalStep[T, R]) ValidateStages(stages [][]Step[T, R]) error {
+	if validator, ok := s.step.(StageValidator[T, R]); ok {
+		return validator.ValidateStages(stages)
+	}
+	return nil
+}
+
+// markNotApplicable sets the conditions managed by the step to True with
+// NotApplicableReason
+func (s *conditionalStep[T, R]) markNotApplicable(r R) {
+	r.GetInstanceLock().Lock()
+	defer r.GetInstanceLock().Unlock()
+
+	instance, ok := any(r.GetInstance()).(instanceWithConditions)
+	if !ok || instance.GetConditions() == nil {
+		return
+	}
+	conditions := instance.GetConditions()
+	for _, cond := range s.GetManagedConditions() {
+		conditions.Set(&condition.Condition{
+			Type:     cond.Type,
+			Status:   corev1.ConditionTrue,
+			Reason:   NotApplicableReason,
+			Severity: condition.SeverityNone,
+			Message:  s.GetName() + " is not applicable",
+		})
+	}
+	instance.SetConditions(conditions)
+}
+
+func (s *conditionalStep[T, R]) isApplied(r R) bool {
+	r.GetInstanceLock().Lock()
+	defer r.GetInstanceLock().Unlock()
+
+	_, found := appliedSteps(r.GetInstance())[s.GetName()]
+	return found
+}
+
+// setApplied records in the AppliedStepsAnnotation of the instance if the
+// step is applied
+func (s *conditionalStep[T, R]) setApplied(r R, applied bool) {
+	r.GetInstanceLock().Lock()
+	defer r.GetInstanceLock().Unlock()
+
+	instance := r.GetInstance()
+	steps := appliedSteps(instance)
+	if _, found := steps[s.GetName()]; found == applied {
+		return
+	}
+	if applied {
+		steps[s.GetName()] = struct{}{}
+	} else {
+		delete(steps, s.GetName())
+	}
+
+	annotations := instance.GetAnnotations()
+	if len(steps) == 0 {
+		delete(annotations, AppliedStepsAnnotation)
+	} else {
+		if annotations == nil {
+			annotations = map[string]string{}
+		}
+		names := []string{}
+		for name := range steps {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		annotations[AppliedStepsAnnotation] = strings.Join(names, ",")
+	}
+	instance.SetAnnotations(annotations)
+}
+
+func appliedSteps(instance client.Object) map[string]struct{} {
+	steps := map[string]struct{}{}
+	value := instance.GetAnnotations()[AppliedStepsAnnotation]
+	if value == "" {
+		return steps
+	}
+	for _, name := range strings.Split(value, ",") {
+		steps[name] = struct{}{}
+	}
+	return steps
+}
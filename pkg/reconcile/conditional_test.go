@@ -0,0 +1,135 @@
+package reconcile
+
+import (
+	"testing"
+
+	"github.com/go-logr/logr"
+	"github.com/openstack-k8s-operators/lib-common/modules/common/condition"
+	corev1 "k8s.io/api/core/v1"
+	ctrl "sigs.k8s.io/controller-runtime"
+
+	. "github.com/onsi/gomega"
+)
+
+func TestWhenRunsStepIfApplicable(t *testing.T) {
+	g := NewWithT(t)
+	c := newTestClient(newTestInstance())
+	applicable := true
+	doCalls := 0
+	cleanupCalls := 0
+	handler, err := NewReqHandler[*corev1.Pod, *TestReq]().
+		WithSteps(When(
+			func(r *TestReq) bool { return applicable },
+			TestStep(FuncStep{
+				name: "step1",
+				do: func(r *TestReq) Result {
+					doCalls++
+					return r.OK()
+				},
+				cleanup: func(r *TestReq) Result {
+					cleanupCalls++
+					return r.OK()
+				},
+			}),
+		)).
+		Build()
+	g.Expect(err).NotTo(HaveOccurred())
+
+	g.Expect(handler.handleReqWithReport(newTestReq(c), &Report{}).IsOK()).To(BeTrue())
+	g.Expect(doCalls).To(Equal(1))
+	instance := &corev1.Pod{}
+	g.Expect(c.Get(ctx, testInstanceName, instance)).To(Succeed())
+	g.Expect(instance.Annotations).To(HaveKeyWithValue(AppliedStepsAnnotation, "step1"))
+
+	// the step is cleaned up once when it becomes not applicable
+	applicable = false
+	g.Expect(handler.handleReqWithReport(newTestReq(c), &Report{}).IsOK()).To(BeTrue())
+	g.Expect(handler.handleReqWithReport(newTestReq(c), &Report{}).IsOK()).To(BeTrue())
+	g.Expect(doCalls).To(Equal(1))
+	g.Expect(cleanupCalls).To(Equal(1))
+	g.Expect(c.Get(ctx, testInstanceName, instance)).To(Succeed())
+	g.Expect(instance.Annotations).NotTo(HaveKey(AppliedStepsAnnotation))
+}
+
+func TestUnlessSkipsStepNeverApplied(t *testing.T) {
+	g := NewWithT(t)
+	c := newTestClient(newTestInstance())
+	called := false
+	handler, err := NewReqHandler[*corev1.Pod, *TestReq]().
+		WithSteps(Unless(
+			func(r *TestReq) bool { return true },
+			TestStep(FuncStep{
+				name: "step1",
+				do: func(r *TestReq) Result {
+					called = true
+					return r.OK()
+				},
+				cleanup: func(r *TestReq) Result {
+					called = true
+					return r.OK()
+				},
+			}),
+		)).
+		Build()
+	g.Expect(err).NotTo(HaveOccurred())
+
+	g.Expect(handler.handleReqWithReport(newTestReq(c), &Report{}).IsOK()).To(BeTrue())
+	g.Expect(called).To(BeFalse())
+}
+
+func TestWrappedStepMatchesTypeDependency(t *testing.T) {
+	g := NewWithT(t)
+
+	handler, err := NewReqHandler[*corev1.Pod, *TestReq]().
+		WithSteps(
+			DependentStep{
+				NamedStep: NamedStep{name: "step1"},
+				deps:      []Dependency{OnStepType[NamedStep]()},
+			},
+			When(func(r *TestReq) bool { return true }, TestStep(NamedStep{name: "step2"})),
+		).
+		Build()
+
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(stepNames(handler.steps)).To(Equal([]string{"step2", "step1"}))
+}
+
+type ConditionReq struct {
+	DefaultReq[*PodWithConditions]
+}
+
+type ConditionManagerStep struct {
+	BaseStep[*PodWithConditions, *ConditionReq]
+}
+
+func (s ConditionManagerStep) GetName() string {
+	return "step1"
+}
+
+func (s ConditionManagerStep) GetManagedConditions() condition.Conditions {
+	return condition.Conditions{
+		*condition.UnknownCondition(condition.InputReadyCondition, condition.InitReason, ""),
+	}
+}
+
+func (s ConditionManagerStep) Do(r *ConditionReq, log logr.Logger) Result {
+	return r.OK()
+}
+
+func TestWhenMarksConditionsNotApplicable(t *testing.T) {
+	g := NewWithT(t)
+	instance := &PodWithConditions{}
+	instance.SetConditions(condition.Conditions{})
+	r := &ConditionReq{DefaultReq: DefaultReq[*PodWithConditions]{Instance: instance}}
+	step := When(
+		func(r *ConditionReq) bool { return false },
+		Step[*PodWithConditions, *ConditionReq](ConditionManagerStep{}))
+
+	g.Expect(step.Do(r, ctrl.Log).IsOK()).To(BeTrue())
+
+	conditions := instance.GetConditions()
+	cond := conditions.Get(condition.InputReadyCondition)
+	g.Expect(cond).NotTo(BeNil())
+	g.Expect(cond.Status).To(Equal(corev1.ConditionTrue))
+	g.Expect(cond.Reason).To(Equal(NotApplicableReason))
+}
@@ -0,0 +1,125 @@
+package reconcile
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+
+	. "github.com/onsi/gomega"
+)
+
+var errInput = errors.New("wrong input")
+
+type ContinueStep struct {
+	FuncStep
+}
+
+func (s ContinueStep) ContinueOnError() bool {
+	return true
+}
+
+func failingStep(name string, deps ...Dependency) FuncStep {
+	return FuncStep{name: name, deps: deps, do: func(r *TestReq) Result {
+		return r.Error(fmt.Errorf("%s failed: %w", name, errInput), r.GetLog())
+	}}
+}
+
+func recordingStep(name string, run map[string]bool, deps ...Dependency) FuncStep {
+	return FuncStep{name: name, deps: deps, do: func(r *TestReq) Result {
+		run[name] = true
+		return r.OK()
+	}}
+}
+
+func TestContinueOnErrorSkipsOnlyDependents(t *testing.T) {
+	g := NewWithT(t)
+	run := map[string]bool{}
+	handler, err := NewReqHandler[*corev1.Pod, *TestReq]().
+		WithSteps(
+			failingStep("step1"),
+			recordingStep("step2", run, OnStep("step1")),
+			recordingStep("step3", run, OnStep("step2")),
+			failingStep("step4"),
+			recordingStep("step5", run),
+		).
+		WithContinueOnError().
+		Build()
+	g.Expect(err).NotTo(HaveOccurred())
+
+	result := handler.handleReqWithReport(newTestReq(newTestClient(newTestInstance())), &Report{})
+
+	g.Expect(run).To(Equal(map[string]bool{"step5": true}))
+	g.Expect(result.IsError()).To(BeTrue())
+	g.Expect(errors.Is(result.Err(), errInput)).To(BeTrue())
+	var stepErrs *StepErrors
+	g.Expect(errors.As(result.Err(), &stepErrs)).To(BeTrue())
+	g.Expect(stepErrs.Steps).To(Equal([]string{"step1", "step4"}))
+	g.Expect(result.Err().Error()).To(Equal(
+		"steps step1, step4 failed: step1: step1 failed: wrong input; " +
+			"step4: step4 failed: wrong input"))
+}
+
+func TestContinueOnErrorPerStep(t *testing.T) {
+	g := NewWithT(t)
+	run := map[string]bool{}
+	handler, err := NewReqHandler[*corev1.Pod, *TestReq]().
+		WithSteps(
+			ContinueStep{failingStep("step1")},
+			recordingStep("step2", run),
+			failingStep("step3"),
+			recordingStep("step4", run),
+		).
+		Build()
+	g.Expect(err).NotTo(HaveOccurred())
+
+	result := handler.handleReqWithReport(newTestReq(newTestClient(newTestInstance())), &Report{})
+
+	// step3 does not continue on error so step4 is not run
+	g.Expect(run).To(Equal(map[string]bool{"step2": true}))
+	var stepErrs *StepErrors
+	g.Expect(errors.As(result.Err(), &stepErrs)).To(BeTrue())
+	g.Expect(stepErrs.Steps).To(Equal([]string{"step1", "step3"}))
+}
+
+func TestContinueOnErrorSingleFailureIsReported(t *testing.T) {
+	g := NewWithT(t)
+	handler, err := NewReqHandler[*corev1.Pod, *TestReq]().
+		WithSteps(
+			ContinueStep{failingStep("step1")},
+			FuncStep{name: "step2"},
+		).
+		Build()
+	g.Expect(err).NotTo(HaveOccurred())
+
+	result := handler.handleReqWithReport(newTestReq(newTestClient(newTestInstance())), &Report{})
+
+	msg, reported := reconcileErrorMsg(result)
+	g.Expect(reported).To(BeTrue())
+	g.Expect(msg).To(ContainSubstring("steps step1 failed"))
+}
+
+func TestContinueOnErrorInParallel(t *testing.T) {
+	g := NewWithT(t)
+	run := map[string]bool{}
+	handler, err := NewReqHandler[*corev1.Pod, *TestReq]().
+		WithSteps(
+			failingStep("step1"),
+			failingStep("step2"),
+			recordingStep("step3", run, OnStep("step1")),
+			recordingStep("step4", run, OnStep("step0")),
+			recordingStep("step0", run),
+		).
+		WithParallelExecution().
+		WithContinueOnError().
+		Build()
+	g.Expect(err).NotTo(HaveOccurred())
+
+	result := handler.handleReqWithReport(newTestReq(newTestClient(newTestInstance())), &Report{})
+
+	g.Expect(run).To(Equal(map[string]bool{"step0": true, "step4": true}))
+	var stepErrs *StepErrors
+	g.Expect(errors.As(result.Err(), &stepErrs)).To(BeTrue())
+	g.Expect(stepErrs.Steps).To(Equal([]string{"step1", "step2"}))
+}
@@ -0,0 +1,95 @@
+package reconcile
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// DeletionPolicy defines what happens with the external resources of the CR
+// when the CR is deleted
+type DeletionPolicy string
+
+const (
+	// DeletionPolicyDelete runs the Cleanup of every step, this is the
+	// default
+	DeletionPolicyDelete DeletionPolicy = "Delete"
+	// DeletionPolicyOrphan skips the Cleanup of the steps implementing
+	// StepWithOrphanableResources so their resources are kept
+	DeletionPolicyOrphan DeletionPolicy = "Orphan"
+
+	// DeletionPolicyAnnotation sets the DeletionPolicy of the CR if the CR
+	// does not implement InstanceWithDeletionPolicy
+	DeletionPolicyAnnotation = "okofw.openstack.org/deletion-policy"
+)
+
+// InstanceWithDeletionPolicy is an optional interface a CR can implement to
+// define its DeletionPolicy, e.g. from a Spec field. An empty policy falls
+// back to the DeletionPolicyAnnotation.
+type InstanceWithDeletionPolicy interface {
+	GetDeletionPolicy() DeletionPolicy
+}
+
+// StepWithOrphanableResources is an optional interface a Step can implement
+// to declare that the resources it creates can be kept when the CR is
+// deleted with DeletionPolicyOrphan. Its Cleanup is not run in that case.
+// GetOrphanableResources returns the description of those resources, e.g.
+// "secret/foo", to record what is orphaned.
+type StepWithOrphanableResources[T client.Object, R Req[T]] interface {
+	GetOrphanableResources(r R) []string
+}
+
+// getDeletionPolicy returns the DeletionPolicy of the instance or an error
+// if the policy is not valid
+func getDeletionPolicy(instance client.Object) (DeletionPolicy, error) {
+	var policy DeletionPolicy
+	if obj, ok := instance.(InstanceWithDeletionPolicy); ok {
+		policy = obj.GetDeletionPolicy()
+	}
+	if policy == "" {
+		policy = DeletionPolicy(instance.GetAnnotations()[DeletionPolicyAnnotation])
+	}
+
+	switch policy {
+	case "":
+		return DeletionPolicyDelete, nil
+	case DeletionPolicyDelete, DeletionPolicyOrphan:
+		return policy, nil
+	default:
+		return "", fmt.Errorf(
+			"invalid deletion policy %q, it should be %s or %s",
+			policy, DeletionPolicyDelete, DeletionPolicyOrphan)
+	}
+}
+
+// orphanableResources returns the resources of the step that are kept by
+// DeletionPolicyOrphan or false if the step cannot orphan its resources
+func orphanableResources[T client.Object, R Req[T]](step any, r R) ([]string, bool) {
+	// a step wrapped e.g. by When() orphans the resources of the wrapped step
+	if wrapper, ok := step.(wrappedStep); ok {
+		return orphanableResources[T](wrapper.unwrapStep(), r)
+	}
+	s, ok := step.(StepWithOrphanableResources[T, R])
+	if !ok {
+		return nil, false
+	}
+	return s.GetOrphanableResources(r), true
+}
+
+// recordOrphanedResources logs the orphaned resources and emits an event
+// about them. The instance is removed right after so the event is the record
+// of them that outlives the instance.
+func recordOrphanedResources[T client.Object, R Req[T]](r R, resources []string) {
+	if len(resources) == 0 {
+		return
+	}
+	sort.Strings(resources)
+	r.GetLog().Info("Orphaned resources due to the deletion policy", "resources", resources)
+	r.GetEventRecorder().Eventf(
+		r.GetInstance(), corev1.EventTypeNormal, ResourcesOrphanedReason,
+		"Resources kept due to the %s deletion policy: %s",
+		DeletionPolicyOrphan, strings.Join(resources, ", "))
+}
@@ -0,0 +1,138 @@
+package reconcile
+
+import (
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/tools/record"
+
+	. "github.com/onsi/gomega"
+)
+
+type OrphanableStep struct {
+	FuncStep
+	resources []string
+}
+
+func (s OrphanableStep) GetOrphanableResources(r *TestReq) []string {
+	return s.resources
+}
+
+type PodWithDeletionPolicy struct {
+	corev1.Pod
+	policy DeletionPolicy
+}
+
+func (p *PodWithDeletionPolicy) GetDeletionPolicy() DeletionPolicy {
+	return p.policy
+}
+
+func newDeletedTestInstance(policy string) *corev1.Pod {
+	instance := newTestInstance()
+	now := metav1.Now()
+	instance.DeletionTimestamp = &now
+	// keep the instance around after our finalizer is removed
+	instance.Finalizers = append(instance.Finalizers, "other")
+	if policy != "" {
+		instance.Annotations = map[string]string{DeletionPolicyAnnotation: policy}
+	}
+	return instance
+}
+
+func newDeletionTestSteps(cleanups *[]string) []TestStep {
+	record := func(name string) func(r *TestReq) Result {
+		return func(r *TestReq) Result {
+			*cleanups = append(*cleanups, name)
+			return r.OK()
+		}
+	}
+	return []TestStep{
+		FuncStep{name: "step1", cleanup: record("step1")},
+		OrphanableStep{
+			FuncStep:  FuncStep{name: "step2", cleanup: record("step2")},
+			resources: []string{"secret/foo"},
+		},
+		When(
+			func(r *TestReq) bool { return true },
+			TestStep(OrphanableStep{
+				FuncStep:  FuncStep{name: "step3", cleanup: record("step3")},
+				resources: []string{"configmap/bar"},
+			})),
+	}
+}
+
+func TestDeletionPolicyDeleteRunsEveryCleanup(t *testing.T) {
+	g := NewWithT(t)
+	c := newTestClient(newDeletedTestInstance(""))
+	cleanups := []string{}
+	handler, err := NewReqHandler[*corev1.Pod, *TestReq]().
+		WithSteps(newDeletionTestSteps(&cleanups)...).
+		Build()
+	g.Expect(err).NotTo(HaveOccurred())
+
+	g.Expect(handler.handleReqWithReport(newTestReq(c), &Report{}).IsOK()).To(BeTrue())
+
+	g.Expect(cleanups).To(Equal([]string{"step3", "step2", "step1"}))
+	instance := &corev1.Pod{}
+	g.Expect(c.Get(ctx, testInstanceName, instance)).To(Succeed())
+	g.Expect(instance.Finalizers).To(Equal([]string{"other"}))
+}
+
+func TestDeletionPolicyOrphanSkipsOrphanableCleanup(t *testing.T) {
+	g := NewWithT(t)
+	c := newTestClient(newDeletedTestInstance(string(DeletionPolicyOrphan)))
+	cleanups := []string{}
+	handler, err := NewReqHandler[*corev1.Pod, *TestReq]().
+		WithSteps(newDeletionTestSteps(&cleanups)...).
+		Build()
+	g.Expect(err).NotTo(HaveOccurred())
+
+	recorder := record.NewFakeRecorder(10)
+	r := newTestReq(c)
+	r.Recorder = recorder
+
+	g.Expect(handler.handleReqWithReport(r, &Report{}).IsOK()).To(BeTrue())
+
+	g.Expect(cleanups).To(Equal([]string{"step1"}))
+	g.Expect(recorder.Events).To(HaveLen(1))
+	g.Expect(<-recorder.Events).To(Equal(
+		"Normal ResourcesOrphaned Resources kept due to the Orphan deletion " +
+			"policy: configmap/bar, secret/foo"))
+	instance := &corev1.Pod{}
+	g.Expect(c.Get(ctx, testInstanceName, instance)).To(Succeed())
+	g.Expect(instance.Finalizers).To(Equal([]string{"other"}))
+}
+
+func TestInvalidDeletionPolicyKeepsFinalizer(t *testing.T) {
+	g := NewWithT(t)
+	c := newTestClient(newDeletedTestInstance("Keep"))
+	cleanups := []string{}
+	handler, err := NewReqHandler[*corev1.Pod, *TestReq]().
+		WithSteps(newDeletionTestSteps(&cleanups)...).
+		Build()
+	g.Expect(err).NotTo(HaveOccurred())
+
+	result := handler.handleReqWithReport(newTestReq(c), &Report{})
+
+	g.Expect(result.IsTerminal()).To(BeTrue())
+	g.Expect(result.Err()).To(MatchError(ContainSubstring(`invalid deletion policy "Keep"`)))
+	g.Expect(cleanups).To(BeEmpty())
+	instance := &corev1.Pod{}
+	g.Expect(c.Get(ctx, testInstanceName, instance)).To(Succeed())
+	g.Expect(instance.Finalizers).To(Equal([]string{"pod-finalizer", "other"}))
+}
+
+func TestDeletionPolicyOfInstanceOverridesAnnotation(t *testing.T) {
+	g := NewWithT(t)
+	instance := &PodWithDeletionPolicy{Pod: *newDeletedTestInstance(string(DeletionPolicyDelete))}
+
+	policy, err := getDeletionPolicy(instance)
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(policy).To(Equal(DeletionPolicyDelete))
+
+	instance.policy = DeletionPolicyOrphan
+	policy, err = getDeletionPolicy(instance)
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(policy).To(Equal(DeletionPolicyOrphan))
+}
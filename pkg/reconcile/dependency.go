@@ -0,0 +1,195 @@
+package reconcile
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// Dependency identifies the Step(s) another Step depends on. Use OnStep() or
+// OnStepType() to create one.
+type Dependency struct {
+	name string
+	typ  reflect.Type
+}
+
+// OnStep returns a Dependency on the Step with the given name
+func OnStep(name string) Dependency {
+	return Dependency{name: name}
+}
+
+// OnStepType returns a Dependency on every Step with type S. E.g.
+// OnStepType[*steps.Conditions[T, R]](). If S is an interface then the
+// Dependency is on every Step implementing it.
+func OnStepType[S any]() Dependency {
+	return Dependency{typ: reflect.TypeOf((*S)(nil)).Elem()}
+}
+
+func (d Dependency) String() string {
+	if d.typ != nil {
+		return "type " + d.typ.String()
+	}
+	return "step " + d.name
+}
+
+func (d Dependency) matches(stepName string, step any) bool {
+	if d.typ != nil {
+		// a step wrapped e.g. by When() still matches its own type
+		if wrapper, ok := step.(wrappedStep); ok {
+			return d.matches(stepName, wrapper.unwrapStep())
+		}
+		if d.typ.Kind() == reflect.Interface {
+			return reflect.TypeOf(step).Implements(d.typ)
+		}
+		return reflect.TypeOf(step) == d.typ
+	}
+	return stepName == d.name
+}
+
+// wrappedStep is implemented by Steps wrapping another Step
+type wrappedStep interface {
+	unwrapStep() any
+}
+
+// StepWithDependencies is an optional interface a Step can implement to
+// declare which other Steps need to be executed before it. The Handler
+// orders the Steps based on these dependencies. The Do and Post phases are
+// executed in dependency order while the Cleanup phase is executed in the
+// reverse dependency order.
+type StepWithDependencies interface {
+	GetDependencies() []Dependency
+}
+
+// resolveDependencies returns the list of indexes of the steps each step
+// depends on.
+func resolveDependencies[T client.Object, R Req[T]](
+	steps []Step[T, R], implicitDeps [][]int,
+) ([][]int, error) {
+	deps := make([][]int, len(steps))
+	for i, step := range steps {
+		deps[i] = append(deps[i], implicitDeps[i]...)
+		stepWithDeps, ok := step.(StepWithDependencies)
+		if !ok {
+			continue
+		}
+		for _, dep := range stepWithDeps.GetDependencies() {
+			found := false
+			for j, other := range steps {
+				if dep.matches(other.GetName(), other) {
+					found = true
+					deps[i] = append(deps[i], j)
+				}
+			}
+			if !found {
+				return nil, &ValidationError{
+					Reason: UnknownDependency,
+					Step:   step.GetName(),
+					Msg: fmt.Sprintf(
+						"Step %s depends on %s but no such step is added",
+						step.GetName(), dep),
+				}
+			}
+		}
+	}
+	return deps, nil
+}
+
+// reverseDependencies returns the dependency graph with all the edges
+// reversed
+func reverseDependencies(deps [][]int) [][]int {
+	rdeps := make([][]int, len(deps))
+	for i, stepDeps := range deps {
+		for _, j := range stepDeps {
+			rdeps[j] = append(rdeps[j], i)
+		}
+	}
+	return rdeps
+}
+
+// topologicalOrder returns the order of the steps where every step comes
+// after the steps it depends on. If there are multiple possible orders then
+// the one closest to the original order of the steps (or the reverse of it if
+// reversed is true) is returned.
+func topologicalOrder[T client.Object, R Req[T]](
+	steps []Step[T, R], deps [][]int, reversed bool,
+) ([]Step[T, R], error) {
+	done := make([]bool, len(steps))
+	order := []Step[T, R]{}
+
+	isReady := func(i int) bool {
+		if done[i] {
+			return false
+		}
+		for _, dep := range deps[i] {
+			if !done[dep] {
+				return false
+			}
+		}
+		return true
+	}
+
+	for len(order) < len(steps) {
+		next := -1
+		for k := range steps {
+			i := k
+			if reversed {
+				i = len(steps) - 1 - k
+			}
+			if isReady(i) {
+				next = i
+				break
+			}
+		}
+		if next == -1 {
+			// every remaining step waits for another remaining step
+			remaining := []string{}
+			for i, step := range steps {
+				if !done[i] {
+					remaining = append(remaining, step.GetName())
+				}
+			}
+			return nil, &ValidationError{
+				Reason: DependencyCycle,
+				Step:   remaining[0],
+				Msg: fmt.Sprintf(
+					"Dependency cycle between steps: %s",
+					strings.Join(remaining, ", ")),
+			}
+		}
+		done[next] = true
+		order = append(order, steps[next])
+	}
+	return order, nil
+}
+
+// groupStages groups the already ordered steps into stages where every step
+// only depends on steps from earlier stages. Within a stage the steps keep
+// their relative order.
+func groupStages[T client.Object, R Req[T]](
+	steps []Step[T, R], deps [][]int, ordered []Step[T, R],
+) [][]Step[T, R] {
+	index := map[string]int{}
+	for i, step := range steps {
+		index[step.GetName()] = i
+	}
+
+	levels := make([]int, len(steps))
+	stages := [][]Step[T, R]{}
+	for _, step := range ordered {
+		i := index[step.GetName()]
+		for _, dep := range deps[i] {
+			// as the steps are ordered the level of the dependency is already
+			// calculated
+			if levels[dep]+1 > levels[i] {
+				levels[i] = levels[dep] + 1
+			}
+		}
+		if levels[i] == len(stages) {
+			stages = append(stages, []Step[T, R]{})
+		}
+		stages[levels[i]] = append(stages[levels[i]], step)
+	}
+	return stages
+}
@@ -0,0 +1,156 @@
+package reconcile
+
+import (
+	"errors"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+
+	. "github.com/onsi/gomega"
+)
+
+type DependentStep struct {
+	NamedStep
+	deps []Dependency
+}
+
+func (s DependentStep) GetDependencies() []Dependency {
+	return s.deps
+}
+
+func stepNames(steps []TestStep) []string {
+	names := []string{}
+	for _, step := range steps {
+		names = append(names, step.GetName())
+	}
+	return names
+}
+
+func TestBuildKeepsInsertionOrderWithoutDependencies(t *testing.T) {
+	g := NewWithT(t)
+
+	handler, err := NewReqHandler[*corev1.Pod, *TestReq]().
+		WithSteps(
+			NamedStep{name: "step1"},
+			NamedStep{name: "step2"},
+			NamedStep{name: "step3"},
+		).
+		Build()
+
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(stepNames(handler.steps)).To(Equal([]string{"step1", "step2", "step3"}))
+	g.Expect(stepNames(handler.cleanupSteps)).To(Equal([]string{"step3", "step2", "step1"}))
+}
+
+func TestBuildOrdersByDependencies(t *testing.T) {
+	g := NewWithT(t)
+
+	handler, err := NewReqHandler[*corev1.Pod, *TestReq]().
+		WithSteps(
+			DependentStep{
+				NamedStep: NamedStep{name: "step1"},
+				deps:      []Dependency{OnStep("step3")},
+			},
+			NamedStep{name: "step2"},
+			DependentStep{
+				NamedStep: NamedStep{name: "step3"},
+				deps:      []Dependency{OnStepType[NamedStep]()},
+			},
+		).
+		Build()
+
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(stepNames(handler.steps)).To(Equal([]string{"step2", "step3", "step1"}))
+	g.Expect(stepNames(handler.cleanupSteps)).To(Equal([]string{"step1", "step3", "step2"}))
+}
+
+// Marked is only implemented by MarkedStep
+type Marked interface {
+	marked()
+}
+
+type MarkedStep struct {
+	NamedStep
+}
+
+func (s MarkedStep) marked() {}
+
+func TestBuildOrdersByInterfaceDependencies(t *testing.T) {
+	g := NewWithT(t)
+
+	handler, err := NewReqHandler[*corev1.Pod, *TestReq]().
+		WithSteps(
+			FuncStep{name: "step1", deps: []Dependency{OnStepType[Marked]()}},
+			MarkedStep{NamedStep{name: "step2"}},
+		).
+		Build()
+
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(stepNames(handler.steps)).To(Equal([]string{"step2", "step1"}))
+}
+
+func TestBuildCleanupOrderFollowsDependencyGraph(t *testing.T) {
+	g := NewWithT(t)
+
+	// reversing the added order would cleanup step2 before step3 even
+	// though step3 depends on step2
+	handler, err := NewReqHandler[*corev1.Pod, *TestReq]().
+		WithSteps(
+			NamedStep{name: "step1"},
+			DependentStep{
+				NamedStep: NamedStep{name: "step3"},
+				deps:      []Dependency{OnStep("step2")},
+			},
+			DependentStep{
+				NamedStep: NamedStep{name: "step2"},
+				deps:      []Dependency{OnStep("step1")},
+			},
+		).
+		Build()
+
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(stepNames(handler.steps)).To(Equal([]string{"step1", "step2", "step3"}))
+	g.Expect(stepNames(handler.cleanupSteps)).To(Equal([]string{"step3", "step2", "step1"}))
+}
+
+func TestBuildUnknownDependency(t *testing.T) {
+	g := NewWithT(t)
+
+	_, err := NewReqHandler[*corev1.Pod, *TestReq]().
+		WithSteps(
+			DependentStep{
+				NamedStep: NamedStep{name: "step1"},
+				deps:      []Dependency{OnStep("missing")},
+			},
+		).
+		Build()
+
+	var vErr *ValidationError
+	g.Expect(errors.As(err, &vErr)).To(BeTrue())
+	g.Expect(vErr.Reason).To(Equal(UnknownDependency))
+	g.Expect(vErr.Step).To(Equal("step1"))
+	g.Expect(vErr.Msg).To(ContainSubstring("step missing"))
+}
+
+func TestBuildDependencyCycle(t *testing.T) {
+	g := NewWithT(t)
+
+	_, err := NewReqHandler[*corev1.Pod, *TestReq]().
+		WithSteps(
+			NamedStep{name: "step0"},
+			DependentStep{
+				NamedStep: NamedStep{name: "step1"},
+				deps:      []Dependency{OnStep("step2")},
+			},
+			DependentStep{
+				NamedStep: NamedStep{name: "step2"},
+				deps:      []Dependency{OnStep("step1")},
+			},
+		).
+		Build()
+
+	var vErr *ValidationError
+	g.Expect(errors.As(err, &vErr)).To(BeTrue())
+	g.Expect(vErr.Reason).To(Equal(DependencyCycle))
+	g.Expect(vErr.Msg).To(ContainSubstring("step1, step2"))
+}
@@ -1,178 +1,1005 @@
 package reconcile
 
 import (
+	"errors"
 	"fmt"
+	"runtime/debug"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/go-logr/logr"
+	"go.opentelemetry.io/otel/trace"
 	k8s_errors "k8s.io/apimachinery/pkg/api/errors"
-	"k8s.io/utils/pointer"
+	"k8s.io/apimachinery/pkg/types"
 	ctrl "sigs.k8s.io/controller-runtime"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
 )
 
-// ReqHandlerBuilder helps building a ReqHandler.
+// ReqHandlerBuilder helps building a Handler.
 // It is not intended for direct use. Use NewReqHandler() instead.
 type ReqHandlerBuilder[T client.Object, R Req[T]] struct {
 	steps []Step[T, R]
+	// stageDeps holds the dependencies of each step implied by the stages
+	stageDeps [][]int
+	// every step added later depends on the first barrier number of steps
+	barrier             int
+	parallel            bool
+	continueOnError     bool
+	persistence         PersistenceStrategy[T]
+	conflictPolicy      ConflictPolicy
+	backoffBase         time.Duration
+	backoffMax          time.Duration
+	blockPausedDeletion bool
+	controllerName      string
+	tracer              trace.Tracer
+	interceptors        []Interceptor[T, R]
+	stepLogLevel        int
 }
 
-// NewReqHandler builds up a function that can handle the current reconcile
-// request for CRD type T with reconcile request type R. It can be configured
-// with the functions on the returned builder to add reconciliation steps.
+// NewReqHandler returns a builder that can be used to define how the
+// reconcile requests for CRD type T with reconcile request type R are
+// handled. It can be configured with the functions on the returned builder to
+// add reconciliation steps. Then Build() creates the Handler that can be used
+// to handle every reconcile request.
 //
-// Step.Do() is called in the order of the Steps added to the handler when
-// CR is reconciled normally.
-// Step.Cleanup() called in the reverse order of the Steps added when the CR is
-// being deleted.
-// Step.Post() is called in order of the Steps added after all the Step's Do or
+// The Steps are ordered based on the dependencies they declare via the
+// StepWithDependencies interface. Steps without dependencies between them
+// are kept in the order they are added to the handler.
+// Step.Do() is called in the above order when CR is reconciled normally.
+// StepWithCleanup.Cleanup() called in the reverse dependency order when the
+// CR is being deleted, so a Step is cleaned up before the Steps it depends
+// on. StepWithPost.Post() is called in the same order as Do() after all the Step's Do or
 // Cleanup function is executed, or one of those functions returned error or
 // requested requeue.
 func NewReqHandler[T client.Object, R Req[T]]() *ReqHandlerBuilder[T, R] {
-	return &ReqHandlerBuilder[T, R]{}
+	return &ReqHandlerBuilder[T, R]{
+		persistence:    MergePatch[T]{},
+		conflictPolicy: RequeueOnConflict,
+		backoffBase:    DefaultBackoffBase,
+		backoffMax:     DefaultBackoffMax,
+		controllerName: defaultControllerName[T](),
+		tracer:         defaultTracer{},
+		stepLogLevel:   DefaultStepLogLevel,
+	}
 }
 
 // WithSteps adds steps to handle the reconciliation of the instance T
 func (builder *ReqHandlerBuilder[T, R]) WithSteps(steps ...Step[T, R]) *ReqHandlerBuilder[T, R] {
-	builder.steps = append(builder.steps, steps...)
+	for _, step := range steps {
+		builder.addStep(step)
+	}
+	return builder
+}
+
+// WithStage adds steps as a single stage. Every step in the stage depends on
+// every step added before the stage, and every step added after the stage
+// depends on every step in the stage. The steps within the stage only depend
+// on each other if they declare it via StepWithDependencies.
+func (builder *ReqHandlerBuilder[T, R]) WithStage(steps ...Step[T, R]) *ReqHandlerBuilder[T, R] {
+	builder.barrier = len(builder.steps)
+	builder.WithSteps(steps...)
+	builder.barrier = len(builder.steps)
+	return builder
+}
+
+func (builder *ReqHandlerBuilder[T, R]) addStep(step Step[T, R]) {
+	deps := []int{}
+	for i := 0; i < builder.barrier; i++ {
+		deps = append(deps, i)
+	}
+	builder.steps = append(builder.steps, step)
+	builder.stageDeps = append(builder.stageDeps, deps)
+}
+
+// WithParallelExecution enables the concurrent execution of the Do phase of
+// independent steps. The steps are grouped into stages based on their
+// dependencies (see StepWithDependencies and WithStage). A stage only
+// contains steps whose dependencies are all in earlier stages. The Do of
+// the steps in the same stage run concurrently on the same request and the
+// next stage is only started if every step in the stage succeeded. Note that
+// in this mode the order in which the steps are added is not considered, only
+// the dependencies are.
+//
+// Steps running in parallel need to hold the lock returned by
+// Req.GetInstanceLock() while they access the instance. Steps implementing
+// StageValidator can reject a stage setup, e.g. if two steps in the same
+// stage would update the same condition. The steps in the same stage share
+// the longest timeout of the stage so a stage mixing steps with and without
+// a timeout is rejected.
+//
+// The Cleanup and Post phases are always executed sequentially.
+func (builder *ReqHandlerBuilder[T, R]) WithParallelExecution() *ReqHandlerBuilder[T, R] {
+	builder.parallel = true
 	return builder
 }
 
-// Handle builds the request handler for the request and executes defined steps
-// to reconcile the request
-func (builder *ReqHandlerBuilder[T, R]) Handle(request R) (ctrl.Result, error) {
-	request.GetLog().Info("Reconciling")
-	result := handleReq[T, R](request, builder.steps)
+// WithPersistenceStrategy defines how the instance is persisted at the end
+// of the reconciliation. By default MergePatch is used.
+func (builder *ReqHandlerBuilder[T, R]) WithPersistenceStrategy(
+	strategy PersistenceStrategy[T],
+) *ReqHandlerBuilder[T, R] {
+	builder.persistence = strategy
+	return builder
+}
+
+// WithContinueOnError requests that if the Do of a step fails then the Do of
+// the rest of the steps still runs, except the ones depending on the failed
+// step directly or indirectly. The errors of the steps are joined into a
+// single StepErrors. Use StepWithContinueOnError to enable this only for
+// some of the steps.
+func (builder *ReqHandlerBuilder[T, R]) WithContinueOnError() *ReqHandlerBuilder[T, R] {
+	builder.continueOnError = true
+	return builder
+}
+
+// WithPausedDeletionBlocked requests that the deletion of a CR is not
+// processed while its reconciliation is paused via the PausedAnnotation, so
+// the Cleanup of the steps only runs after the reconciliation resumes. By
+// default a paused CR is still deleted.
+func (builder *ReqHandlerBuilder[T, R]) WithPausedDeletionBlocked() *ReqHandlerBuilder[T, R] {
+	builder.blockPausedDeletion = true
+	return builder
+}
+
+// WithControllerName defines the value of the controller label of the
+// metrics recorded by the Handler and of the controller key of its log lines.
+// By default the lower case kind of the CR is used, the same as the default
+// name of the controller.
+func (builder *ReqHandlerBuilder[T, R]) WithControllerName(name string) *ReqHandlerBuilder[T, R] {
+	builder.controllerName = name
+	return builder
+}
+
+// WithTracerProvider defines the TracerProvider used to create the spans
+// of the reconciliations. By default the global TracerProvider is used, see
+// otel.SetTracerProvider().
+func (builder *ReqHandlerBuilder[T, R]) WithTracerProvider(
+	provider trace.TracerProvider,
+) *ReqHandlerBuilder[T, R] {
+	builder.tracer = provider.Tracer(TracerName)
+	return builder
+}
+
+// WithInterceptors adds Interceptors wrapping the call of every phase of
+// every step, e.g. to add audit logging. The first Interceptor added is the
+// outermost one.
+func (builder *ReqHandlerBuilder[T, R]) WithInterceptors(
+	interceptors ...Interceptor[T, R],
+) *ReqHandlerBuilder[T, R] {
+	builder.interceptors = append(builder.interceptors, interceptors...)
+	return builder
+}
+
+// WithStepLogLevel defines the verbosity level used to log a step result that
+// is the same as the result of the step in the previous reconciliation of the
+// instance. Changed results are logged at the normal level and errors are
+// always logged. By default DefaultStepLogLevel is used.
+func (builder *ReqHandlerBuilder[T, R]) WithStepLogLevel(level int) *ReqHandlerBuilder[T, R] {
+	builder.stepLogLevel = level
+	return builder
+}
+
+// WithBackoff defines the delay of the first requeue requested via
+// RequeueWithBackoff and the maximum delay the subsequent requeues can grow
+// to. By default DefaultBackoffBase and DefaultBackoffMax is used.
+func (builder *ReqHandlerBuilder[T, R]) WithBackoff(
+	base time.Duration, max time.Duration,
+) *ReqHandlerBuilder[T, R] {
+	builder.backoffBase = base
+	builder.backoffMax = max
+	return builder
+}
+
+// WithConflictPolicy defines what to do if persisting the instance fails
+// with a Conflict error. By default RequeueOnConflict is used. Use it together
+// with a PersistenceStrategy doing optimistic locking, e.g.
+// MergePatch{OptimisticLock: true}, to detect conflicting updates.
+func (builder *ReqHandlerBuilder[T, R]) WithConflictPolicy(
+	policy ConflictPolicy,
+) *ReqHandlerBuilder[T, R] {
+	builder.conflictPolicy = policy
+	return builder
+}
+
+// Build validates the requested steps, runs the Setup of each step once and
+// returns a Handler that can be reused for every reconcile request. It is
+// expected to be called once when the controller is set up with the manager.
+// If the steps cannot form a valid Handler then a *ValidationError is
+// returned.
+func (builder *ReqHandlerBuilder[T, R]) Build() (*Handler[T, R], error) {
+	log := ctrl.Log.WithName("ReqHandlerBuilder")
+
+	names := map[string]bool{}
+	for _, step := range builder.steps {
+		if names[step.GetName()] {
+			return nil, &ValidationError{
+				Reason: DuplicateStepName,
+				Step:   step.GetName(),
+				Msg: fmt.Sprintf(
+					"Step name %s is used by more than one step", step.GetName()),
+			}
+		}
+		names[step.GetName()] = true
+	}
+
+	deps, err := resolveDependencies(builder.steps, builder.stageDeps)
+	if err != nil {
+		return nil, err
+	}
+	// NOTE(gibi): these are new slices so later changes in the builder do not
+	// affect the Handler
+	steps, err := topologicalOrder(builder.steps, deps, false)
+	if err != nil {
+		return nil, err
+	}
+	cleanupSteps, err := topologicalOrder(
+		builder.steps, reverseDependencies(deps), true)
+	if err != nil {
+		return nil, err
+	}
+
+	// In sequential mode every step is a stage on its own
+	stages := [][]Step[T, R]{}
+	for _, step := range steps {
+		stages = append(stages, []Step[T, R]{step})
+	}
+	if builder.parallel {
+		stages = groupStages(builder.steps, deps, steps)
+	}
+
+	for _, step := range steps {
+		err := step.Setup(steps, log.WithName(step.GetName()))
+		if err != nil {
+			var vErr *ValidationError
+			if errors.As(err, &vErr) {
+				return nil, err
+			}
+			return nil, &ValidationError{
+				Reason: InvalidStepSetup,
+				Step:   step.GetName(),
+				Msg: fmt.Sprintf(
+					"Setup of step %s failed: %v", step.GetName(), err),
+			}
+		}
+	}
+
+	if builder.parallel {
+		if err := validateStageTimeouts(stages); err != nil {
+			return nil, err
+		}
+		for _, step := range steps {
+			validator, ok := step.(StageValidator[T, R])
+			if !ok {
+				continue
+			}
+			err := validator.ValidateStages(stages)
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	// dependencies by step name to be able to skip the dependents of a
+	// failed step
+	dependencies := map[string][]string{}
+	for i, step := range builder.steps {
+		for _, dep := range deps[i] {
+			dependencies[step.GetName()] = append(
+				dependencies[step.GetName()], builder.steps[dep].GetName())
+		}
+	}
+
+	return &Handler[T, R]{
+		steps:               steps,
+		cleanupSteps:        cleanupSteps,
+		stages:              stages,
+		dependencies:        dependencies,
+		continueOnErrorAll:  builder.continueOnError,
+		persistence:         builder.persistence,
+		conflictPolicy:      builder.conflictPolicy,
+		backoff:             newBackoff(builder.backoffBase, builder.backoffMax),
+		terminal:            newTerminalFailures(),
+		blockPausedDeletion: builder.blockPausedDeletion,
+		controllerName:      builder.controllerName,
+		tracer:              builder.tracer,
+		interceptors:        builder.interceptors,
+		stepLogs:            newStepLogs(),
+		stepLogLevel:        builder.stepLogLevel,
+	}, nil
+}
+
+// Handler executes the Steps to reconcile a single request. It is created
+// once by ReqHandlerBuilder.Build() and it is not changed afterwards so
+// it can be reused for every reconcile request.
+type Handler[T client.Object, R Req[T]] struct {
+	// steps in the order of Do and Post execution
+	steps []Step[T, R]
+	// steps in the order of Cleanup execution
+	cleanupSteps []Step[T, R]
+	// steps grouped to stages for Do execution. Steps in the same stage can
+	// be run in parallel
+	stages [][]Step[T, R]
+	// the names of the steps each step depends on
+	dependencies       map[string][]string
+	continueOnErrorAll bool
+	persistence        PersistenceStrategy[T]
+	conflictPolicy     ConflictPolicy
+	backoff            *backoff
+	terminal           *terminalFailures
+	// do not process the deletion of paused instances
+	blockPausedDeletion bool
+	// the controller label of the metrics
+	controllerName string
+	tracer         trace.Tracer
+	interceptors   []Interceptor[T, R]
+	// the last result of the steps to only log the changed ones
+	stepLogs     *stepLogs
+	stepLogLevel int
+	// counters of the patches sent and skipped while persisting the instance
+	issuedWrites  atomic.Uint64
+	skippedWrites atomic.Uint64
+}
+
+// forgetInstance drops every state the Handler keeps about the instance
+// between the reconcile requests, e.g. when the instance is deleted
+func (h *Handler[T, R]) forgetInstance(instance types.NamespacedName) {
+	for _, store := range []instanceForgetter{h.backoff, h.terminal, h.stepLogs} {
+		store.forget(instance)
+	}
+}
+
+// WriteStats holds the number of patches the Handler sent to the API server
+// and the number of patches skipped as the patched part of the instance was
+// not changed by the steps.
+type WriteStats struct {
+	Issued  uint64
+	Skipped uint64
+}
+
+// GetWriteStats returns the number of patches issued and skipped while
+// persisting instances since the Handler was built. The instance and the
+// status patches are counted separately.
+func (h *Handler[T, R]) GetWriteStats() WriteStats {
+	return WriteStats{
+		Issued:  h.issuedWrites.Load(),
+		Skipped: h.skippedWrites.Load(),
+	}
+}
+
+// Handle executes defined steps to reconcile the request
+func (h *Handler[T, R]) Handle(request R) (ctrl.Result, error) {
+	result, _, err := h.HandleWithReport(request)
+	return result, err
+}
+
+// HandleWithReport is the same as Handle but it also returns the Report
+// describing which phases of which steps ran and with what result, and
+// whether the instance was saved.
+func (h *Handler[T, R]) HandleWithReport(request R) (ctrl.Result, *Report, error) {
+	h.addLogKeys(request)
+	request.GetLog().V(1).Info("Reconciling")
+	report := &Report{}
+	result := h.handleReqWithReport(request, report)
 	request.GetLog().Info("Reconciled", "result", result)
-	return result.Unwrap()
+	request.GetLog().V(1).Info("Reconcile report", "report", report.String())
+	ctrlResult, err := result.Unwrap()
+	return ctrlResult, report, err
 }
 
-// handleReq implements a single Reconcile run by going through each
-// reconciliation steps provided.
-func handleReq[T client.Object, R Req[T]](r R, steps []Step[T, R]) Result {
+// handleReqWithReport implements a single Reconcile run by going through each
+// reconciliation steps provided. It records what happened during the request
+// in the report. The report is owned by the Handler and passed along with the
+// request so it is not part of the Req.
+func (h *Handler[T, R]) handleReqWithReport(r R, report *Report) Result {
+	span, restoreCtx := h.startReconcileSpan(r)
+	defer restoreCtx()
 
-	// NOTE(gibi): this is a bit wasteful as steps are static between reconcile
-	// runs so this setup could be done only once at manager setup
-	lateStepSetup(steps, r)
+	result := h.reconcileInstance(r, report)
+	report.Result = result
 
+	if generation := r.GetInstance().GetGeneration(); generation != 0 {
+		span.SetAttributes(GenerationAttribute.Int64(generation))
+	}
+	span.SetAttributes(ResultAttribute.String(result.String()))
+	endSpan(span, result)
+	return result
+}
+
+// reconcileInstance loads the instance, runs the steps on it and persists
+// it
+func (h *Handler[T, R]) reconcileInstance(r R, report *Report) Result {
 	// Read the instance
+	span := h.startSpan(r, "Read")
 	readResult, found := readInstance[T, R](r)
+	endSpan(span, readResult)
 	if !readResult.IsOK() {
 		return readResult
 	}
 	if !found {
 		// Instance not found nothing to reconcile so skip the rest
+		h.forgetInstance(r.GetRequest().NamespacedName)
 		return r.OK()
 	}
+	addGenerationLogKey[T](r)
 
 	// Create a snapshot of the instance to have a base for a diff patch at the
 	// end of the reconciliation
 	r.SnapshotInstance()
 
-	var result Result
-	if !r.GetInstance().GetDeletionTimestamp().IsZero() {
-		result = reconcileDelete(r, steps)
+	var result Result = r.OK()
+	cleanedUp := false
+	paused := isPaused(r.GetInstance())
+	deleting := !r.GetInstance().GetDeletionTimestamp().IsZero()
+	skipped := paused && (!deleting || h.blockPausedDeletion)
+	switch {
+	case skipped:
+		r.GetLog().Info("Reconciliation is paused", "annotation", PausedAnnotation)
+	case deleting:
+		result, cleanedUp = h.reconcileDelete(r, report)
+	default:
+		if !hasFinalizer(r) {
+			// first time we see this instance
+			result = h.runOptionalPhase(r, report, "Init", h.steps, initF[T, R])
+		}
+		if result.IsOK() {
+			result = h.ensureFinalizer(r)
+		}
+		if result.IsOK() {
+			result = h.reconcileUnlessTerminal(r, report)
+			setDoResult(r, result)
+		}
+	}
+
+	if paused {
+		markReconcilePaused(r.GetInstance(), r.GetInstanceSnapshot())
 	} else {
-		result = reconcileNormal(r, steps)
+		clearReconcilePaused(r.GetInstance())
 	}
 
-	postResult := reconcilePost(r, steps)
-	if !postResult.IsOK() {
+	// report a failure the steps could not report themselves, or clear the
+	// report of a previous failure. If the steps did not run as the
+	// reconciliation is paused then the report is kept as is.
+	if msg, ok := reconcileErrorMsg(result); ok {
+		markReconcileFailed(r.GetInstance(), r.GetInstanceSnapshot(), msg)
+	} else if !skipped {
+		clearReconcileFailed(r.GetInstance())
+	}
+
+	postResult := h.reconcilePost(r, report)
+	if msg, ok := reconcileErrorMsg(postResult); ok {
+		markReconcileFailed(r.GetInstance(), r.GetInstanceSnapshot(), msg)
+	}
+
+	saveResult := h.saveInstance(r, report)
+	if saveResult.IsOK() {
+		// only report the condition changes that are persisted otherwise
+		// they would be reported again in the next reconciliation
+		recordConditionChanges(r)
+	}
+
+	finalizeResult := r.OK()
+	if cleanedUp && saveResult.IsOK() {
+		// the finalizer is removed only after every other change is
+		// persisted as the instance might be gone right after
+		finalizeResult = h.removeFinalizer(r)
+		if finalizeResult.IsOK() {
+			h.runFinalize(r, report)
+		}
+	}
+	return MergeResults(result, postResult, saveResult, finalizeResult)
+}
+
+// runFinalize runs the Finalize of each step implementing it in the order of
+// Cleanup. It is best-effort: the instance might be already gone so a failed
+// Finalize cannot be retried in a later reconciliation. The failure is only
+// logged and reported and the rest of the steps are still finalized.
+func (h *Handler[T, R]) runFinalize(r R, report *Report) {
+	l := r.GetLog().WithName("Finalize")
+	for _, step := range h.cleanupSteps {
+		stepF, ok := finalizeF(step)
+		if !ok {
+			continue
+		}
+		h.runStep("Finalize", step.GetName(), stepF, r, report, l, getTimeout[T, R](step))
+	}
+}
+
+// runOptionalPhase runs the function of the phase returned by phaseF for each
+// step implementing it, in the order of the steps. It stops at the first
+// step not succeeding.
+func (h *Handler[T, R]) runOptionalPhase(
+	r R, report *Report, phase string, steps []Step[T, R],
+	phaseF func(step Step[T, R]) (func(r R, log logr.Logger) Result, bool),
+) Result {
+	l := r.GetLog().WithName(phase)
+	for _, step := range steps {
+		stepF, ok := phaseF(step)
+		if !ok {
+			continue
+		}
+		result := h.runStep(phase, step.GetName(), stepF, r, report, l, getTimeout[T, R](step))
 		if !result.IsOK() {
-			r.GetLog().Info(
-				"Post step failure overrides existing negative result",
-				"post step result", postResult, "dropped result", result,
-			)
+			return result
 		}
-		result = postResult
 	}
+	return r.OK()
+}
 
-	saveResult := saveInstance[T, R](r)
-	// intentionally overwrite the normal steps' result only if save
-	// failed so a requeue request or a step error is propagated
-	if !saveResult.IsOK() {
-		return saveResult
+// hasFinalizer returns true if the instance has our finalizer or a legacy
+// one
+func hasFinalizer[T client.Object, R Req[T]](r R) bool {
+	for _, finalizer := range append([]string{r.GetFinalizer()}, r.GetLegacyFinalizers()...) {
+		if finalizer != "" && controllerutil.ContainsFinalizer(r.GetInstance(), finalizer) {
+			return true
+		}
 	}
+	return false
+}
 
-	return result
+// runStep runs a function of the step in the given phase, i.e. Do, Cleanup
+// or Post, and logs its result
+func (h *Handler[T, R]) runStep(
+	phase string, name string, stepF func(r R, log logr.Logger) Result, r R,
+	report *Report, log logr.Logger, timeout time.Duration,
+) Result {
+	run := h.startStep(phase, name, r, log)
+	timedOut := runWithTimeout(r, timeout, func() {
+		h.callStep(run, stepF, r)
+	})
+	if timedOut {
+		run.result = toTimeout(r, timeout, run.result)
+	}
+	return h.finishStep(run, r, report)
 }
 
-// lateStepSetup do late initialization of all steps based on every requested
-// step
-func lateStepSetup[T client.Object, R Req[T]](steps []Step[T, R], r R) {
-	for _, step := range steps {
-		step.Setup(steps, r.GetLog())
+// stepRun holds a single run of a phase of a step from its start until its
+// result is recorded
+type stepRun struct {
+	phase  string
+	name   string
+	log    logr.Logger
+	span   trace.Span
+	start  time.Time
+	end    time.Time
+	result Result
+}
+
+func (h *Handler[T, R]) startStep(phase string, name string, r R, log logr.Logger) *stepRun {
+	return &stepRun{
+		phase: phase,
+		name:  name,
+		log:   log.WithName(name),
+		span: h.startSpan(
+			r, phase+" "+name, StepAttribute.String(name), PhaseAttribute.String(phase)),
+		start: time.Now(),
 	}
 }
 
-func runStep[T client.Object, R Req[T]](name string, stepF func(r R, log logr.Logger) Result, r R, log logr.Logger) Result {
-	stepLog := log.WithName(name)
-	result := stepF(r, stepLog)
+// callStep calls the step function and stores its result in the run. It
+// only touches the run so the steps of a stage can be called concurrently.
+func (h *Handler[T, R]) callStep(
+	run *stepRun, stepF func(r R, log logr.Logger) Result, r R,
+) {
+	run.result = callStep(
+		run.name, h.intercept(StepInfo{Step: run.name, Phase: run.phase}, stepF), r, run.log)
+	run.end = time.Now()
+}
+
+// finishStep records the final result of the run in the backoff, the
+// metrics, the report, the span, the log and the events. It is called
+// sequentially even for the steps of a stage running concurrently, after the
+// result is converted to a Timeout if the stage timed out.
+func (h *Handler[T, R]) finishStep(run *stepRun, r R, report *Report) Result {
+	result := h.backoff.apply(r.GetRequest().NamespacedName, run.phase, run.name, run.result)
+	duration := run.end.Sub(run.start)
+	observeStep(h.controllerName, run.phase, run.name, duration, result)
+	report.addStep(StepReport{
+		Step: run.name, Phase: run.phase, Duration: duration, Result: result})
+	endSpan(run.span, result, trace.WithTimestamp(run.end))
+	h.logStepResult(r, run.phase, run.name, result, run.log)
 	if result.IsError() {
-		stepLog.Error(result.Err(), result.String())
-	} else {
-		stepLog.Info(result.String())
+		recordStepFailure(r, run.phase, run.name, result)
 	}
 	return result
 }
 
-func reconcileNormal[T client.Object, R Req[T]](r R, steps []Step[T, R]) Result {
-	// before we change anything ensure that we have our own finalizer set so
-	// we can catch Instance delete and do a proper cleanup
-	updated := controllerutil.AddFinalizer(r.GetInstance(), r.GetFinalizer())
-	if updated {
+// ensureFinalizer adds our own finalizer to the instance and persists it
+// immediately. We need to have our own finalizer persisted before we try to
+// create any external resources so we can catch Instance delete and do a
+// proper cleanup. Legacy finalizers are replaced with our finalizer in the
+// same patch so the instance is always protected.
+func (h *Handler[T, R]) ensureFinalizer(r R) Result {
+	finalizer := r.GetFinalizer()
+	if finalizer == "" {
+		err := fmt.Errorf("cannot determine the finalizer of the instance")
+		return r.Error(err, r.GetLog())
+	}
+
+	instance := r.GetInstance()
+	obj := instance.DeepCopyObject().(T)
+	legacy := []string{}
+	for _, name := range r.GetLegacyFinalizers() {
+		if name != finalizer {
+			legacy = append(legacy, name)
+		}
+	}
+	migrated := removeFinalizers(obj, legacy)
+	added := controllerutil.AddFinalizer(obj, finalizer)
+	if !migrated && !added {
+		return r.OK()
+	}
+
+	// Patch only the finalizers and use the resourceVersion as a lock so a
+	// concurrent change of the finalizers is not overwritten
+	base := instance.DeepCopyObject().(T)
+	h.issuedWrites.Add(1)
+	err := r.GetClient().Patch(
+		r.GetCtx(), obj,
+		client.MergeFromWithOptions(base, client.MergeFromWithOptimisticLock{}))
+	if err != nil {
+		if k8s_errors.IsConflict(err) {
+			return r.Conflict(fmt.Errorf("cannot persist finalizer: %w", err))
+		}
+		err := fmt.Errorf("failed to persist finalizer: %w", err)
+		return r.Error(err, r.GetLog())
+	}
+	if migrated {
+		r.GetLog().Info("Replaced legacy finalizer", "finalizer", finalizer)
+	} else {
 		r.GetLog().Info("Added finalizer to ourselves")
-		// we intentionally force a requeue immediately here to persist the
-		// Instance with the finalizer. We need to have our own
-		// finalizer persisted before we try to create any external resources
-		return r.RequeueAfter(
-			"Requeue to get our finalizer persisted before continue",
-			pointer.Duration(r.GetDefaultRequeueTimeout()),
-		)
 	}
 
-	for _, step := range steps {
-		result := runStep[T, R](step.GetName(), step.Do, r, r.GetLog())
-		if !result.IsOK() {
+	// Continue with the persisted finalizers in the base of the final
+	// patches. The snapshot is not retaken as other changes made to the
+	// instance so far, e.g. by Init, are not persisted yet.
+	for _, target := range []client.Object{instance, r.GetInstanceSnapshot()} {
+		target.SetFinalizers(obj.GetFinalizers())
+		target.SetResourceVersion(obj.GetResourceVersion())
+	}
+	return r.OK()
+}
+
+// PanicError is the error of the Result of a step that panicked
+type PanicError struct {
+	// Step is the name of the step that panicked
+	Step string
+	// Value is the value passed to panic
+	Value interface{}
+}
+
+func (e *PanicError) Error() string {
+	return fmt.Sprintf("step %s panicked: %v", e.Step, e.Value)
+}
+
+// StepErrors is the error of the Result if multiple steps failed, or the
+// Handler continued after a failed step. It supports errors.Is and errors.As
+// on the errors of the steps.
+type StepErrors struct {
+	// Steps are the names of the failed steps
+	Steps []string
+	// Errs are the errors of the failed steps in the same order
+	Errs []error
+}
+
+func (e *StepErrors) Error() string {
+	msgs := []string{}
+	for i, err := range e.Errs {
+		msgs = append(msgs, fmt.Sprintf("%s: %v", e.Steps[i], err))
+	}
+	return fmt.Sprintf(
+		"steps %s failed: %s",
+		strings.Join(e.Steps, ", "), strings.Join(msgs, "; "))
+}
+
+func (e *StepErrors) Is(target error) bool {
+	return (&joinedError{errs: e.Errs}).Is(target)
+}
+
+func (e *StepErrors) As(target interface{}) bool {
+	return (&joinedError{errs: e.Errs}).As(target)
+}
+
+// asPanicError returns the PanicError of the result if any
+func asPanicError(result Result) (*PanicError, bool) {
+	var panicErr *PanicError
+	ok := errors.As(result.Err(), &panicErr)
+	return panicErr, ok
+}
+
+// reconcileErrorMsg returns the message to report in the
+// ReconcileSucceededCondition if the result has an error the steps could not
+// report themselves
+func reconcileErrorMsg(result Result) (string, bool) {
+	var stepErrs *StepErrors
+	if errors.As(result.Err(), &stepErrs) {
+		return stepErrs.Error(), true
+	}
+	if panicErr, ok := asPanicError(result); ok {
+		return panicErr.Error(), true
+	}
+	if result.IsTerminal() {
+		return result.Err().Error(), true
+	}
+	return "", false
+}
+
+// callStep calls the step function and converts a panic to an error Result
+// so the rest of the reconciliation, including saving the instance, can
+// still run
+func callStep[T client.Object, R Req[T]](
+	name string, stepF func(r R, log logr.Logger) Result, r R, log logr.Logger,
+) (result Result) {
+	defer func() {
+		if p := recover(); p != nil {
+			err := &PanicError{Step: name, Value: p}
+			log.Error(err, "Recovered from panic", "stack", string(debug.Stack()))
+			result = DefaultResult{err: err}
+		}
+	}()
+	return stepF(r, log)
+}
+
+// reconcileUnlessTerminal runs the Do of the steps unless the same
+// generation of the instance already failed with a terminal error.
+func (h *Handler[T, R]) reconcileUnlessTerminal(r R, report *Report) Result {
+	name := r.GetRequest().NamespacedName
+	generation := r.GetInstance().GetGeneration()
+	if err, found := h.terminal.get(name, generation); found {
+		r.GetLog().Info(
+			"Skipping steps as this generation already failed with a "+
+				"terminal error", "generation", generation, "error", err)
+		return r.Terminal(err)
+	}
+
+	result := h.reconcileNormal(r, report)
+	if result.IsTerminal() {
+		h.terminal.record(name, generation, result.Err())
+	} else {
+		h.terminal.forget(name)
+	}
+	return result
+}
+
+// reconcileNormal runs the Do of the steps stage by stage. It stops after
+// the stage where a step failed unless the step can continue on error. In
+// that case only the steps depending on the failed step are skipped.
+func (h *Handler[T, R]) reconcileNormal(r R, report *Report) Result {
+	// steps that failed or skipped due to a failed dependency
+	failed := map[string]bool{}
+	failures := []stepResult{}
+	continued := false
+	for _, stage := range h.stages {
+		toRun := []Step[T, R]{}
+		for _, step := range stage {
+			if dep := h.failedDependency(step, failed); dep != "" {
+				r.GetLog().Info(
+					"Skipping step as a step it depends on failed",
+					"step", step.GetName(), "dependency", dep)
+				failed[step.GetName()] = true
+				continue
+			}
+			toRun = append(toRun, step)
+		}
+
+		stop := false
+		for i, result := range h.runStage(r, report, toRun) {
+			if result.IsOK() {
+				continue
+			}
+			step := toRun[i]
+			failed[step.GetName()] = true
+			failures = append(failures, stepResult{step: step.GetName(), result: result})
+			if h.continueOnError(step) {
+				continued = true
+			} else {
+				stop = true
+			}
+		}
+		if stop {
 			// stop progressing as something failed
-			return result
+			break
 		}
 	}
-	return r.OK()
+	return mergeStepResults(r, failures, continued)
+}
+
+// failedDependency returns the name of a dependency of the step that failed
+// or an empty string if none of them failed
+func (h *Handler[T, R]) failedDependency(step Step[T, R], failed map[string]bool) string {
+	for _, dep := range h.dependencies[step.GetName()] {
+		if failed[dep] {
+			return dep
+		}
+	}
+	return ""
+}
+
+func (h *Handler[T, R]) continueOnError(step Step[T, R]) bool {
+	if h.continueOnErrorAll {
+		return true
+	}
+	s, ok := step.(StepWithContinueOnError)
+	return ok && s.ContinueOnError()
 }
 
-func reconcileDelete[T client.Object, R Req[T]](r R, steps []Step[T, R]) Result {
-	r.GetLog().Info("Deleting instance")
+// runStage runs the Do of each step of the stage concurrently and returns
+// the result of each step in the order of the steps in the stage
+func (h *Handler[T, R]) runStage(r R, report *Report, stage []Step[T, R]) []Result {
+	if len(stage) == 1 {
+		return []Result{h.runStep(
+			"Do", stage[0].GetName(), stage[0].Do, r, report, r.GetLog(),
+			getTimeout[T, R](stage[0]))}
+	}
+
+	// the context is shared by the steps so they share the timeout too
+	timeout := getStageTimeout(stage)
+	runs := make([]*stepRun, len(stage))
+	for i, step := range stage {
+		runs[i] = h.startStep("Do", step.GetName(), r, r.GetLog())
+	}
+	timedOut := runWithTimeout(r, timeout, func() {
+		var wg sync.WaitGroup
+		for i, step := range stage {
+			wg.Add(1)
+			go func(run *stepRun, step Step[T, R]) {
+				defer wg.Done()
+				h.callStep(run, step.Do, r)
+			}(runs[i], step)
+		}
+		wg.Wait()
+	})
+
+	// the results are recorded only after every step of the stage finished
+	// so a timeout is recorded as such
+	results := make([]Result, len(stage))
+	for i, run := range runs {
+		if timedOut {
+			run.result = toTimeout(r, timeout, run.result)
+		}
+		results[i] = h.finishStep(run, r, report)
+	}
+	return results
+}
+
+// stepResult is the result of a given step
+type stepResult struct {
+	step   string
+	result Result
+}
+
+// mergeStepResults returns a single result from the results of failed
+// steps via MergeResults. The results are considered in the order of the
+// steps so the merged result is independent of the order of step
+// completion. If there are multiple errors or the engine continued after a
+// failed step then the errors are joined into StepErrors.
+func mergeStepResults[T client.Object, R Req[T]](
+	r R, failures []stepResult, continued bool,
+) Result {
+	stepErrs := &StepErrors{}
+	results := []Result{}
+	for _, failure := range failures {
+		if failure.result.IsError() {
+			stepErrs.Steps = append(stepErrs.Steps, failure.step)
+			stepErrs.Errs = append(stepErrs.Errs, failure.result.Err())
+		}
+		results = append(results, failure.result)
+	}
+
+	merged := MergeResults(results...)
+	if len(stepErrs.Errs) > 1 || (len(stepErrs.Errs) == 1 && continued) {
+		r.GetLog().Error(stepErrs, "Steps failed", "steps", stepErrs.Steps)
+		return DefaultResult{err: stepErrs, terminal: merged.IsTerminal()}
+	}
+	return merged
+}
+
+// reconcileDelete runs the PreDelete of the steps in order then the Cleanup
+// of the steps in reverse order. With DeletionPolicyOrphan the Cleanup of the
+// steps with orphanable resources is skipped. It returns true if all
+// succeeded and our finalizer is still there to be removed.
+func (h *Handler[T, R]) reconcileDelete(r R, report *Report) (Result, bool) {
+	policy, err := getDeletionPolicy(r.GetInstance())
+	if err != nil {
+		// retrying does not help until the policy is fixed
+		return r.Terminal(err), false
+	}
+	r.GetLog().Info("Deleting instance", "deletionPolicy", policy)
+
+	result := h.runOptionalPhase(r, report, "PreDelete", h.steps, preDeleteF[T, R])
+	if !result.IsOK() {
+		return result, false
+	}
+
 	l := r.GetLog().WithName("Cleanup")
+	orphaned := []string{}
 
-	// Do the cleanup calls in reverse order so the last created resource
-	// cleaned up first
-	for _, step := range reverse(steps) {
-		result := runStep[T, R](step.GetName(), step.Cleanup, r, l)
+	// The steps are already in reverse dependency order so the resource
+	// created last is cleaned up first
+	for _, step := range h.cleanupSteps {
+		stepF, ok := cleanupF(step)
+		if !ok {
+			continue
+		}
+		if policy == DeletionPolicyOrphan {
+			if resources, ok := orphanableResources[T](step, r); ok {
+				l.Info("Skipped as the resources are orphaned", "step", step.GetName())
+				orphaned = append(orphaned, resources...)
+				continue
+			}
+		}
+		result := h.runStep(
+			"Cleanup", step.GetName(), stepF, r, report, l, getTimeout[T, R](step))
 		if !result.IsOK() {
 			// skip the rest of the cleanups it will be done in a later
 			// reconcile
-			return result
+			return result, false
 		}
 	}
 
-	// all cleanups are done successfully so we can remove the finalizer
-	// from ourselves
-	updated := controllerutil.RemoveFinalizer(r.GetInstance(), r.GetFinalizer())
-	if updated {
-		r.GetLog().Info("Removed finalizer from ourselves")
+	recordOrphanedResources[T](r, orphaned)
+
+	// all cleanups are done successfully so the finalizer can be removed
+	// from ourselves after the instance is saved
+	return r.OK(), hasFinalizer(r)
+}
+
+// removeFinalizer removes our own finalizer, and the legacy ones in case the
+// instance was not migrated yet, from the instance. It is the counterpart of
+// ensureFinalizer so the finalizers are patched directly instead of via the
+// PersistenceStrategy as e.g. server-side apply would not remove a finalizer
+// that was not applied by its field manager.
+func (h *Handler[T, R]) removeFinalizer(r R) Result {
+	// Start from the current finalizers of the instance as the save might
+	// have changed the resourceVersion without updating the instance
+	obj := r.GetInstance().DeepCopyObject().(T)
+	err := r.GetClient().Get(r.GetCtx(), r.GetRequest().NamespacedName, obj)
+	if k8s_errors.IsNotFound(err) {
+		r.GetLog().Info("Cannot remove finalizer as the instance is deleted")
+		return r.OK()
+	}
+	if err != nil {
+		err := fmt.Errorf("failed to read instance to remove finalizer: %w", err)
+		return r.Error(err, r.GetLog())
 	}
 
+	base := obj.DeepCopyObject().(T)
+	finalizers := append([]string{r.GetFinalizer()}, r.GetLegacyFinalizers()...)
+	if !removeFinalizers(obj, finalizers) {
+		return r.OK()
+	}
+	h.issuedWrites.Add(1)
+	err = r.GetClient().Patch(
+		r.GetCtx(), obj,
+		client.MergeFromWithOptions(base, client.MergeFromWithOptimisticLock{}))
+	if err != nil {
+		if k8s_errors.IsNotFound(err) {
+			r.GetLog().Info("Cannot remove finalizer as the instance is deleted")
+			return r.OK()
+		}
+		if k8s_errors.IsConflict(err) {
+			return r.Conflict(fmt.Errorf("cannot remove finalizer: %w", err))
+		}
+		err := fmt.Errorf("failed to remove finalizer: %w", err)
+		return r.Error(err, r.GetLog())
+	}
+	r.GetLog().Info("Removed finalizer from ourselves")
+	r.GetInstance().SetFinalizers(obj.GetFinalizers())
 	return r.OK()
 }
 
-// Reverse a slice. Replace this with slices.Reverse() from golang 1.21
-func reverse[S ~[]E, E any](s S) S {
-	r := []E{}
-	for i := len(s) - 1; i >= 0; i-- {
-		r = append(r, s[i])
+// removeFinalizers removes all the finalizers from the object and returns
+// true if any of them was present
+func removeFinalizers(obj client.Object, finalizers []string) bool {
+	updated := false
+	for _, finalizer := range finalizers {
+		if finalizer != "" && controllerutil.RemoveFinalizer(obj, finalizer) {
+			updated = true
+		}
 	}
-	return r
+	return updated
 }
 
 func readInstance[T client.Object, R Req[T]](r R) (result Result, found bool) {
@@ -193,49 +1020,99 @@ func readInstance[T client.Object, R Req[T]](r R) (result Result, found bool) {
 	return r.OK(), true
 }
 
-func reconcilePost[T client.Object, R Req[T]](r R, steps []Step[T, R]) Result {
+func (h *Handler[T, R]) reconcilePost(r R, report *Report) Result {
 	l := r.GetLog().WithName("Post")
-	// FIXME(gibi): This is to much log with empty function. Either we
-	// should check if the function is empty and not run / log it
-	// or only log error in optional phases.
-	// Also double check if cleanup logging happening properly
-	for _, step := range steps {
-		result := runStep[T, R](step.GetName(), step.Post, r, l)
-		if !result.IsOK() {
-			return result
+	results := []Result{}
+	for _, step := range h.steps {
+		// steps without Post are not called so they are not logged either
+		stepF, ok := postF(step)
+		if !ok {
+			continue
+		}
+		// Post gets a fresh budget even if the Do of the step timed out
+		result := h.runStep(
+			"Post", step.GetName(), stepF, r, report, l, getTimeout[T, R](step))
+		results = append(results, result)
+		if result.IsError() {
+			break
 		}
 	}
-	return r.OK()
+	return MergeResults(results...)
 }
 
-func saveInstance[T client.Object, R Req[T]](r R) Result {
-	patch := client.MergeFrom(r.GetInstanceSnapshot())
+func (h *Handler[T, R]) saveInstance(r R, report *Report) Result {
+	// Diff both parts before patching as the patch can update the
+	// resourceVersion of the instance
+	instanceChanged, err := changed(r, isNotStatusPath)
+	if err != nil {
+		return r.Error(fmt.Errorf("failed to diff instance: %w", err), r.GetLog())
+	}
+	statusChanged, err := changed(r, isStatusPath)
+	if err != nil {
+		return r.Error(fmt.Errorf("failed to diff instance status: %w", err), r.GetLog())
+	}
 
-	// We need to patch the Instance to allow metadata (finalizer) update and
-	// need a separate patch call for the Status. We need to pass a copy to
-	// Patch as it will reset the Status fields by reading back the object
-	// after Patching the non status part.
-	instance := r.GetInstance().DeepCopyObject().(T)
-	err := r.GetClient().Patch(r.GetCtx(), instance, patch)
+	if instanceChanged {
+		h.issuedWrites.Add(1)
+		span := h.startSpan(r, "Save instance")
+		start := time.Now()
+		err = h.persistence.PatchInstance(
+			r.GetCtx(), r.GetClient(), r.GetInstance(), r.GetInstanceSnapshot())
+		observeSave(h.controllerName, "instance", time.Since(start))
+		endSpanWithErr(span, err)
+		report.InstanceSaved = err == nil
+	} else {
+		h.skippedWrites.Add(1)
+	}
 	if err != nil {
 		if k8s_errors.IsNotFound(err) {
 			r.GetLog().Info("Cannot persist instance as it is deleted")
 			return r.OK()
 		}
+		if k8s_errors.IsConflict(err) {
+			return r.Conflict(fmt.Errorf("cannot persist instance: %w", err))
+		}
 
 		err := fmt.Errorf("failed to persist instance: %w", err)
 		return r.Error(err, r.GetLog())
 	}
 
-	err = r.GetClient().Status().Patch(r.GetCtx(), r.GetInstance(), patch)
+	if !statusChanged {
+		h.skippedWrites.Add(1)
+		return r.OK()
+	}
+	h.issuedWrites.Add(1)
+	span := h.startSpan(r, "Save status")
+	start := time.Now()
+	err = h.persistence.PatchStatus(
+		r.GetCtx(), r.GetClient(), r.GetInstance(), r.GetInstanceSnapshot())
+	if k8s_errors.IsConflict(err) && h.conflictPolicy == RetryStatusOnConflict {
+		r.GetLog().Info("Conflict while persisting instance status, retrying", "error", err)
+		err = retryStatusPatch(
+			r.GetCtx(), r.GetClient(), h.persistence,
+			r.GetInstance(), r.GetInstanceSnapshot())
+	}
+	observeSave(h.controllerName, "status", time.Since(start))
+	endSpanWithErr(span, err)
 	if err != nil {
 		if k8s_errors.IsNotFound(err) {
 			r.GetLog().Info("Cannot persist instance status as it is deleted")
 			return r.OK()
 		}
+		if k8s_errors.IsConflict(err) {
+			return r.Conflict(fmt.Errorf("cannot persist instance status: %w", err))
+		}
 
 		err := fmt.Errorf("failed to persist instance status: %w", err)
 		return r.Error(err, r.GetLog())
 	}
+	report.StatusSaved = true
 	return r.OK()
 }
+
+// changed returns true if the part of the instance selected by the filter
+// differs from the snapshot of the instance
+func changed[T client.Object, R Req[T]](r R, filter func(path string) bool) (bool, error) {
+	ops, err := diff(r.GetInstanceSnapshot(), r.GetInstance(), filter)
+	return len(ops) > 0, err
+}
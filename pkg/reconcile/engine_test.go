@@ -0,0 +1,509 @@
+package reconcile
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/go-logr/logr"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes/scheme"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	. "github.com/onsi/gomega"
+)
+
+type TestReq struct {
+	DefaultReq[*corev1.Pod]
+}
+
+type TestStep = Step[*corev1.Pod, *TestReq]
+
+type NamedStep struct {
+	BaseStep[*corev1.Pod, *TestReq]
+	name     string
+	setupErr error
+}
+
+func (s NamedStep) GetName() string {
+	return s.name
+}
+
+func (s NamedStep) Setup(steps []TestStep, log logr.Logger) error {
+	return s.setupErr
+}
+
+func (s NamedStep) Do(r *TestReq, log logr.Logger) Result {
+	return r.OK()
+}
+
+// FuncStep is a step that delegates its phases to the provided functions
+type FuncStep struct {
+	BaseStep[*corev1.Pod, *TestReq]
+	name    string
+	deps    []Dependency
+	do      func(r *TestReq) Result
+	cleanup func(r *TestReq) Result
+	post    func(r *TestReq) Result
+}
+
+func (s FuncStep) GetName() string {
+	return s.name
+}
+
+func (s FuncStep) GetDependencies() []Dependency {
+	return s.deps
+}
+
+func (s FuncStep) Do(r *TestReq, log logr.Logger) Result {
+	if s.do == nil {
+		return r.OK()
+	}
+	return s.do(r)
+}
+
+func (s FuncStep) Cleanup(r *TestReq, log logr.Logger) Result {
+	if s.cleanup == nil {
+		return r.OK()
+	}
+	return s.cleanup(r)
+}
+
+func (s FuncStep) Post(r *TestReq, log logr.Logger) Result {
+	if s.post == nil {
+		return r.OK()
+	}
+	return s.post(r)
+}
+
+var testInstanceName = types.NamespacedName{Namespace: "test-ns", Name: "test"}
+var ctx = context.TODO()
+
+func newTestClient(objs ...client.Object) client.Client {
+	return fake.NewClientBuilder().
+		WithScheme(scheme.Scheme).
+		WithObjects(objs...).
+		Build()
+}
+
+func newTestInstance() *corev1.Pod {
+	return &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace:  testInstanceName.Namespace,
+			Name:       testInstanceName.Name,
+			Finalizers: []string{"pod-finalizer"},
+		},
+	}
+}
+
+func newTestReq(c client.Client) *TestReq {
+	return &TestReq{
+		DefaultReq: DefaultReq[*corev1.Pod]{
+			Ctx:      ctx,
+			Log:      ctrl.Log,
+			Request:  ctrl.Request{NamespacedName: testInstanceName},
+			Client:   c,
+			Instance: &corev1.Pod{},
+		},
+	}
+}
+
+func TestBuild(t *testing.T) {
+	g := NewWithT(t)
+
+	handler, err := NewReqHandler[*corev1.Pod, *TestReq]().
+		WithSteps(NamedStep{name: "step1"}, NamedStep{name: "step2"}).
+		Build()
+
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(handler.steps).To(HaveLen(2))
+}
+
+func TestBuildDuplicateStepName(t *testing.T) {
+	g := NewWithT(t)
+
+	_, err := NewReqHandler[*corev1.Pod, *TestReq]().
+		WithSteps(NamedStep{name: "step1"}, NamedStep{name: "step1"}).
+		Build()
+
+	var vErr *ValidationError
+	g.Expect(errors.As(err, &vErr)).To(BeTrue())
+	g.Expect(vErr.Reason).To(Equal(DuplicateStepName))
+	g.Expect(vErr.Step).To(Equal("step1"))
+}
+
+func TestBuildSetupError(t *testing.T) {
+	g := NewWithT(t)
+
+	_, err := NewReqHandler[*corev1.Pod, *TestReq]().
+		WithSteps(
+			NamedStep{name: "step1"},
+			NamedStep{name: "step2", setupErr: fmt.Errorf("boom")},
+		).
+		Build()
+
+	var vErr *ValidationError
+	g.Expect(errors.As(err, &vErr)).To(BeTrue())
+	g.Expect(vErr.Reason).To(Equal(InvalidStepSetup))
+	g.Expect(vErr.Step).To(Equal("step2"))
+	g.Expect(vErr.Msg).To(ContainSubstring("boom"))
+}
+
+func TestBuildSetupValidationErrorPropagated(t *testing.T) {
+	g := NewWithT(t)
+	setupErr := &ValidationError{Reason: StepOrderViolation, Step: "step1"}
+
+	_, err := NewReqHandler[*corev1.Pod, *TestReq]().
+		WithSteps(NamedStep{name: "step1", setupErr: setupErr}).
+		Build()
+
+	g.Expect(err).To(BeIdenticalTo(setupErr))
+}
+
+func TestBuiltHandlerIsNotAffectedByTheBuilder(t *testing.T) {
+	g := NewWithT(t)
+
+	builder := NewReqHandler[*corev1.Pod, *TestReq]().
+		WithSteps(NamedStep{name: "step1"})
+	handler, err := builder.Build()
+	g.Expect(err).NotTo(HaveOccurred())
+
+	builder.WithSteps(NamedStep{name: "step2"})
+
+	g.Expect(handler.steps).To(HaveLen(1))
+}
+
+// waitForEachOther returns two Do functions that only succeed if they run
+// concurrently
+func waitForEachOther() (func(r *TestReq) Result, func(r *TestReq) Result) {
+	started1 := make(chan struct{})
+	started2 := make(chan struct{})
+	wait := func(started chan struct{}, other chan struct{}) func(r *TestReq) Result {
+		return func(r *TestReq) Result {
+			close(started)
+			select {
+			case <-other:
+				return r.OK()
+			case <-time.After(5 * time.Second):
+				return r.Error(fmt.Errorf("timed out waiting for the other step"), r.GetLog())
+			}
+		}
+	}
+	return wait(started1, started2), wait(started2, started1)
+}
+
+func TestParallelExecutionGroupsIndependentStepsToStages(t *testing.T) {
+	g := NewWithT(t)
+
+	handler, err := NewReqHandler[*corev1.Pod, *TestReq]().
+		WithSteps(
+			FuncStep{name: "step1"},
+			FuncStep{name: "step2"},
+			FuncStep{name: "step3", deps: []Dependency{OnStep("step1")}},
+		).
+		WithParallelExecution().
+		Build()
+
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(handler.stages).To(HaveLen(2))
+	g.Expect(stepNames(handler.stages[0])).To(Equal([]string{"step1", "step2"}))
+	g.Expect(stepNames(handler.stages[1])).To(Equal([]string{"step3"}))
+}
+
+func TestSequentialExecutionHasSingleStepStages(t *testing.T) {
+	g := NewWithT(t)
+
+	handler, err := NewReqHandler[*corev1.Pod, *TestReq]().
+		WithSteps(FuncStep{name: "step1"}, FuncStep{name: "step2"}).
+		Build()
+
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(handler.stages).To(HaveLen(2))
+}
+
+func TestWithStageAddsBarriers(t *testing.T) {
+	g := NewWithT(t)
+
+	handler, err := NewReqHandler[*corev1.Pod, *TestReq]().
+		WithSteps(FuncStep{name: "step1"}).
+		WithStage(FuncStep{name: "step2"}, FuncStep{name: "step3"}).
+		WithSteps(FuncStep{name: "step4"}, FuncStep{name: "step5"}).
+		WithParallelExecution().
+		Build()
+
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(handler.stages).To(HaveLen(3))
+	g.Expect(stepNames(handler.stages[0])).To(Equal([]string{"step1"}))
+	g.Expect(stepNames(handler.stages[1])).To(Equal([]string{"step2", "step3"}))
+	g.Expect(stepNames(handler.stages[2])).To(Equal([]string{"step4", "step5"}))
+}
+
+type RejectStages struct {
+	FuncStep
+}
+
+func (s RejectStages) ValidateStages(stages [][]TestStep) error {
+	return &ValidationError{Reason: StepOrderViolation, Step: s.name}
+}
+
+func TestStageValidatorOnlyCalledInParallelMode(t *testing.T) {
+	g := NewWithT(t)
+
+	_, err := NewReqHandler[*corev1.Pod, *TestReq]().
+		WithSteps(RejectStages{FuncStep{name: "step1"}}).
+		Build()
+	g.Expect(err).NotTo(HaveOccurred())
+
+	_, err = NewReqHandler[*corev1.Pod, *TestReq]().
+		WithSteps(RejectStages{FuncStep{name: "step1"}}).
+		WithParallelExecution().
+		Build()
+	g.Expect(err).To(HaveOccurred())
+}
+
+func TestParallelExecutionRunsStageConcurrently(t *testing.T) {
+	g := NewWithT(t)
+	do1, do2 := waitForEachOther()
+	step3Run := false
+
+	handler, err := NewReqHandler[*corev1.Pod, *TestReq]().
+		WithStage(FuncStep{name: "step1", do: do1}, FuncStep{name: "step2", do: do2}).
+		WithStage(FuncStep{name: "step3", do: func(r *TestReq) Result {
+			step3Run = true
+			return r.OK()
+		}}).
+		WithParallelExecution().
+		Build()
+	g.Expect(err).NotTo(HaveOccurred())
+
+	_, err = handler.Handle(newTestReq(newTestClient(newTestInstance())))
+
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(step3Run).To(BeTrue())
+}
+
+func TestParallelExecutionMergesStageResults(t *testing.T) {
+	g := NewWithT(t)
+	step3Run := false
+	short := 1 * time.Second
+	long := 10 * time.Second
+
+	handler, err := NewReqHandler[*corev1.Pod, *TestReq]().
+		WithStage(
+			FuncStep{name: "step1", do: func(r *TestReq) Result {
+				return r.RequeueAfter("long", &long)
+			}},
+			FuncStep{name: "step2", do: func(r *TestReq) Result {
+				return r.RequeueAfter("short", &short)
+			}},
+		).
+		WithStage(FuncStep{name: "step3", do: func(r *TestReq) Result {
+			step3Run = true
+			return r.OK()
+		}}).
+		WithParallelExecution().
+		Build()
+	g.Expect(err).NotTo(HaveOccurred())
+
+	result, err := handler.Handle(newTestReq(newTestClient(newTestInstance())))
+
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(result.RequeueAfter).To(Equal(short))
+	g.Expect(step3Run).To(BeFalse())
+}
+
+func TestParallelExecutionStageErrorWins(t *testing.T) {
+	g := NewWithT(t)
+	short := 1 * time.Second
+
+	handler, err := NewReqHandler[*corev1.Pod, *TestReq]().
+		WithStage(
+			FuncStep{name: "step1", do: func(r *TestReq) Result {
+				return r.RequeueAfter("short", &short)
+			}},
+			FuncStep{name: "step2", do: func(r *TestReq) Result {
+				return r.Error(fmt.Errorf("boom"), r.GetLog())
+			}},
+		).
+		WithParallelExecution().
+		Build()
+	g.Expect(err).NotTo(HaveOccurred())
+
+	_, err = handler.Handle(newTestReq(newTestClient(newTestInstance())))
+
+	g.Expect(err).To(MatchError("boom"))
+}
+
+// WrappingReq implements Req by wrapping another one but not ReqWithSetters
+type WrappingReq struct {
+	Req[*corev1.Pod]
+}
+
+// WrappingStep is a step with a timeout for WrappingReq
+type WrappingStep struct {
+	BaseStep[*corev1.Pod, *WrappingReq]
+	do func(r *WrappingReq) Result
+}
+
+func (s WrappingStep) GetName() string {
+	return "step1"
+}
+
+func (s WrappingStep) GetTimeout() time.Duration {
+	return time.Minute
+}
+
+func (s WrappingStep) Do(r *WrappingReq, log logr.Logger) Result {
+	return s.do(r)
+}
+
+func TestReqWithoutSettersIsHandled(t *testing.T) {
+	g := NewWithT(t)
+	c := newTestClient(newTestInstance())
+	handler, err := NewReqHandler[*corev1.Pod, *WrappingReq]().
+		WithSteps(WrappingStep{
+			do: func(r *WrappingReq) Result {
+				// the context cannot be replaced so the step gets the
+				// original one without the deadline
+				_, hasDeadline := r.GetCtx().Deadline()
+				g.Expect(hasDeadline).To(BeFalse())
+				r.GetInstance().Labels = map[string]string{"foo": "bar"}
+				return r.OK()
+			},
+		}).
+		Build()
+	g.Expect(err).NotTo(HaveOccurred())
+
+	r := &WrappingReq{Req: newTestReq(c)}
+	g.Expect(handler.handleReqWithReport(r, &Report{}).IsOK()).To(BeTrue())
+
+	instance := &corev1.Pod{}
+	g.Expect(c.Get(ctx, testInstanceName, instance)).To(Succeed())
+	g.Expect(instance.Labels).To(HaveKeyWithValue("foo", "bar"))
+}
+
+func TestFinalizerPersistedBeforeSteps(t *testing.T) {
+	g := NewWithT(t)
+	instance := newTestInstance()
+	instance.Finalizers = []string{"other"}
+	c := newTestClient(instance)
+	stepRun := false
+	handler, err := NewReqHandler[*corev1.Pod, *TestReq]().
+		WithSteps(FuncStep{name: "step1", do: func(r *TestReq) Result {
+			stepRun = true
+			persisted := &corev1.Pod{}
+			g.Expect(c.Get(ctx, testInstanceName, persisted)).To(Succeed())
+			g.Expect(persisted.Finalizers).To(Equal([]string{"other", "pod-finalizer"}))
+			r.GetInstance().Labels = map[string]string{"foo": "bar"}
+			return r.OK()
+		}}).
+		Build()
+	g.Expect(err).NotTo(HaveOccurred())
+
+	result := handler.handleReqWithReport(newTestReq(c), &Report{})
+	g.Expect(result.IsOK()).To(BeTrue())
+	g.Expect(stepRun).To(BeTrue())
+
+	persisted := &corev1.Pod{}
+	g.Expect(c.Get(ctx, testInstanceName, persisted)).To(Succeed())
+	g.Expect(persisted.Finalizers).To(Equal([]string{"other", "pod-finalizer"}))
+	g.Expect(persisted.Labels).To(HaveKeyWithValue("foo", "bar"))
+}
+
+func TestLegacyFinalizerMigrated(t *testing.T) {
+	g := NewWithT(t)
+	instance := newTestInstance()
+	instance.Finalizers = []string{"Pod", "other", "old-finalizer"}
+	c := newTestClient(instance)
+	handler, err := NewReqHandler[*corev1.Pod, *TestReq]().
+		WithSteps(NamedStep{name: "step1"}).
+		Build()
+	g.Expect(err).NotTo(HaveOccurred())
+
+	req := newTestReq(c)
+	req.LegacyFinalizers = []string{"old-finalizer"}
+	result := handler.handleReqWithReport(req, &Report{})
+	g.Expect(result.IsOK()).To(BeTrue())
+
+	persisted := &corev1.Pod{}
+	g.Expect(c.Get(ctx, testInstanceName, persisted)).To(Succeed())
+	g.Expect(persisted.Finalizers).To(Equal([]string{"other", "pod-finalizer"}))
+}
+
+func TestFinalizerOverride(t *testing.T) {
+	g := NewWithT(t)
+	c := newTestClient(newTestInstance())
+	handler, err := NewReqHandler[*corev1.Pod, *TestReq]().
+		WithSteps(NamedStep{name: "step1"}).
+		Build()
+	g.Expect(err).NotTo(HaveOccurred())
+
+	req := newTestReq(c)
+	req.Finalizer = "example.org/custom"
+	req.LegacyFinalizers = []string{"pod-finalizer"}
+	result := handler.handleReqWithReport(req, &Report{})
+	g.Expect(result.IsOK()).To(BeTrue())
+
+	persisted := &corev1.Pod{}
+	g.Expect(c.Get(ctx, testInstanceName, persisted)).To(Succeed())
+	g.Expect(persisted.Finalizers).To(Equal([]string{"example.org/custom"}))
+}
+
+func TestDeleteRemovesLegacyFinalizers(t *testing.T) {
+	g := NewWithT(t)
+	instance := newTestInstance()
+	instance.Finalizers = []string{"Pod", "other"}
+	now := metav1.Now()
+	instance.DeletionTimestamp = &now
+	c := newTestClient(instance)
+	cleanedUp := false
+	handler, err := NewReqHandler[*corev1.Pod, *TestReq]().
+		WithSteps(FuncStep{name: "step1", cleanup: func(r *TestReq) Result {
+			cleanedUp = true
+			return r.OK()
+		}}).
+		Build()
+	g.Expect(err).NotTo(HaveOccurred())
+
+	result := handler.handleReqWithReport(newTestReq(c), &Report{})
+	g.Expect(result.IsOK()).To(BeTrue())
+	g.Expect(cleanedUp).To(BeTrue())
+
+	persisted := &corev1.Pod{}
+	g.Expect(c.Get(ctx, testInstanceName, persisted)).To(Succeed())
+	g.Expect(persisted.Finalizers).To(Equal([]string{"other"}))
+}
+
+func TestDoResultAvailableInPost(t *testing.T) {
+	g := NewWithT(t)
+	c := newTestClient(newTestInstance())
+	var doResult Result
+	handler, err := NewReqHandler[*corev1.Pod, *TestReq]().
+		WithSteps(FuncStep{
+			name: "step1",
+			do:   func(r *TestReq) Result { return r.Requeue("waiting") },
+			post: func(r *TestReq) Result {
+				doResult = r.GetDoResult()
+				return r.OK()
+			},
+		}).
+		Build()
+	g.Expect(err).NotTo(HaveOccurred())
+
+	handler.handleReqWithReport(newTestReq(c), &Report{})
+	g.Expect(doResult).NotTo(BeNil())
+	g.Expect(doResult.IsRequeue()).To(BeTrue())
+
+	instance := &corev1.Pod{}
+	g.Expect(c.Get(ctx, testInstanceName, instance)).To(Succeed())
+	g.Expect(c.Delete(ctx, instance)).To(Succeed())
+
+	handler.handleReqWithReport(newTestReq(c), &Report{})
+	g.Expect(doResult).To(BeNil())
+}
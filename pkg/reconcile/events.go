@@ -0,0 +1,73 @@
+package reconcile
+
+import (
+	"fmt"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+const (
+	// StepFailedReason is the reason of the Warning events emitted by the
+	// Handler when a step fails
+	StepFailedReason = "StepFailed"
+	// ConditionChangedReason is the reason of the Normal events emitted by
+	// the Handler when the status of a condition of the instance changes
+	ConditionChangedReason = "ConditionChanged"
+	// ResourcesOrphanedReason is the reason of the Normal events emitted by
+	// the Handler when resources are kept during the deletion of the
+	// instance due to DeletionPolicyOrphan
+	ResourcesOrphanedReason = "ResourcesOrphaned"
+)
+
+// noopRecorder is used if the request has no EventRecorder so the steps can
+// always emit events
+type noopRecorder struct{}
+
+func (noopRecorder) Event(object runtime.Object, eventtype, reason, message string) {}
+
+func (noopRecorder) Eventf(
+	object runtime.Object, eventtype, reason, messageFmt string, args ...interface{},
+) {
+}
+
+func (noopRecorder) AnnotatedEventf(
+	object runtime.Object, annotations map[string]string,
+	eventtype, reason, messageFmt string, args ...interface{},
+) {
+}
+
+// recordStepFailure emits a Warning event about the failed step. It does
+// not take the instance lock as a failed step might still hold it, so it
+// must not be called while the steps of a stage are running.
+func recordStepFailure[T client.Object, R Req[T]](
+	r R, phase string, name string, result Result,
+) {
+	r.GetEventRecorder().Eventf(
+		r.GetInstance(), corev1.EventTypeWarning, StepFailedReason,
+		"Step %s failed in %s: %s", name, phase, result.Err())
+}
+
+// recordConditionChanges emits a Normal event for each condition of the
+// instance that is added or its status is changed compared to the snapshot.
+// Conditions that did not change do not emit events so a steady state
+// reconciliation is silent.
+func recordConditionChanges[T client.Object, R Req[T]](r R) {
+	obj, ok := any(r.GetInstance()).(instanceWithConditions)
+	if !ok {
+		return
+	}
+	oldConditions := any(r.GetInstanceSnapshot()).(instanceWithConditions).GetConditions()
+	for _, cond := range obj.GetConditions() {
+		if old := oldConditions.Get(cond.Type); old != nil && old.Status == cond.Status {
+			continue
+		}
+		msg := fmt.Sprintf("Condition %s changed to %s", cond.Type, cond.Status)
+		if cond.Message != "" {
+			msg += ": " + cond.Message
+		}
+		r.GetEventRecorder().Event(
+			r.GetInstance(), corev1.EventTypeNormal, ConditionChangedReason, msg)
+	}
+}
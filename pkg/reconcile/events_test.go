@@ -0,0 +1,127 @@
+package reconcile
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/openstack-k8s-operators/lib-common/modules/common/condition"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/client-go/tools/record"
+
+	. "github.com/onsi/gomega"
+)
+
+func TestFailedStepEmitsWarningEvent(t *testing.T) {
+	g := NewWithT(t)
+	c := newTestClient(newTestInstance())
+	handler, err := NewReqHandler[*corev1.Pod, *TestReq]().
+		WithSteps(
+			FuncStep{name: "step1"},
+			FuncStep{name: "step2", do: func(r *TestReq) Result {
+				return r.Error(errors.New("boom"), r.GetLog())
+			}},
+		).
+		Build()
+	g.Expect(err).NotTo(HaveOccurred())
+	recorder := record.NewFakeRecorder(10)
+	r := newTestReq(c)
+	r.Recorder = recorder
+
+	g.Expect(handler.handleReqWithReport(r, &Report{}).IsError()).To(BeTrue())
+
+	g.Expect(recorder.Events).To(HaveLen(1))
+	g.Expect(<-recorder.Events).To(Equal("Warning StepFailed Step step2 failed in Do: boom"))
+}
+
+func TestPanickingParallelStepHoldingTheLockEmitsWarningEvent(t *testing.T) {
+	g := NewWithT(t)
+	c := newTestClient(newTestInstance())
+	handler, err := NewReqHandler[*corev1.Pod, *TestReq]().
+		WithSteps(
+			FuncStep{name: "step1"},
+			FuncStep{name: "step2", do: func(r *TestReq) Result {
+				r.GetInstanceLock().Lock()
+				panic("boom")
+			}},
+		).
+		WithParallelExecution().
+		Build()
+	g.Expect(err).NotTo(HaveOccurred())
+	recorder := record.NewFakeRecorder(10)
+	r := newTestReq(c)
+	r.Recorder = recorder
+
+	done := make(chan Result)
+	go func() {
+		done <- handler.handleReqWithReport(r, &Report{})
+	}()
+	var result Result
+	g.Eventually(done).Should(Receive(&result))
+	g.Expect(result.IsError()).To(BeTrue())
+
+	g.Expect(recorder.Events).To(HaveLen(1))
+	g.Expect(<-recorder.Events).To(Equal(
+		"Warning StepFailed Step step2 failed in Do: step step2 panicked: boom"))
+}
+
+func TestStepCanEmitEvents(t *testing.T) {
+	g := NewWithT(t)
+	c := newTestClient(newTestInstance())
+	handler, err := NewReqHandler[*corev1.Pod, *TestReq]().
+		WithSteps(FuncStep{name: "step1", do: func(r *TestReq) Result {
+			r.GetEventRecorder().Event(
+				r.GetInstance(), corev1.EventTypeNormal, "Created", "created foo")
+			return r.OK()
+		}}).
+		Build()
+	g.Expect(err).NotTo(HaveOccurred())
+
+	// without a recorder the events are dropped
+	g.Expect(handler.handleReqWithReport(newTestReq(c), &Report{}).IsOK()).To(BeTrue())
+
+	recorder := record.NewFakeRecorder(10)
+	r := newTestReq(c)
+	r.Recorder = recorder
+	g.Expect(handler.handleReqWithReport(r, &Report{}).IsOK()).To(BeTrue())
+
+	g.Expect(recorder.Events).To(HaveLen(1))
+	g.Expect(<-recorder.Events).To(Equal("Normal Created created foo"))
+}
+
+func TestConditionChangesEmitEvents(t *testing.T) {
+	g := NewWithT(t)
+	recorder := record.NewFakeRecorder(10)
+	snapshot := &PodWithConditions{}
+	snapshot.SetConditions(condition.Conditions{
+		*condition.UnknownCondition(condition.ReadyCondition, condition.InitReason, "init"),
+		*condition.UnknownCondition(condition.InputReadyCondition, condition.InitReason, "init"),
+	})
+	instance := snapshot.DeepCopyObject().(*PodWithConditions)
+	r := &ConditionReq{DefaultReq: DefaultReq[*PodWithConditions]{
+		Instance:         instance,
+		InstanceSnapshot: snapshot,
+		Recorder:         recorder,
+	}}
+
+	// nothing changed
+	recordConditionChanges(r)
+	g.Expect(recorder.Events).To(BeEmpty())
+
+	// only the message changed
+	conditions := instance.GetConditions()
+	conditions.MarkUnknown(condition.ReadyCondition, condition.InitReason, "still init")
+	instance.SetConditions(conditions)
+	recordConditionChanges(r)
+	g.Expect(recorder.Events).To(BeEmpty())
+
+	// status changed and a new condition is added
+	conditions.MarkTrue(condition.InputReadyCondition, "input ready")
+	conditions.Set(condition.TrueCondition(condition.ServiceConfigReadyCondition, ""))
+	instance.SetConditions(conditions)
+	recordConditionChanges(r)
+	g.Expect(recorder.Events).To(HaveLen(2))
+	g.Expect(<-recorder.Events).To(Equal(
+		"Normal ConditionChanged Condition InputReady changed to True: input ready"))
+	g.Expect(<-recorder.Events).To(Equal(
+		"Normal ConditionChanged Condition ServiceConfigReady changed to True"))
+}
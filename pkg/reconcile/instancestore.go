@@ -0,0 +1,86 @@
+package reconcile
+
+import (
+	"sync"
+
+	"k8s.io/apimachinery/pkg/types"
+)
+
+// instanceStore holds state of the Handler about instances that outlives a
+// single reconcile request, e.g. the backoff attempts of the steps. The
+// values are stored per instance by key. It is shared by the reconcile
+// requests so it is thread safe.
+type instanceStore[K comparable, V any] struct {
+	lock    sync.Mutex
+	entries map[types.NamespacedName]map[K]V
+}
+
+func newInstanceStore[K comparable, V any]() *instanceStore[K, V] {
+	return &instanceStore[K, V]{entries: map[types.NamespacedName]map[K]V{}}
+}
+
+// get returns the value stored for the instance with the key
+func (s *instanceStore[K, V]) get(instance types.NamespacedName, key K) (V, bool) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	value, found := s.entries[instance][key]
+	return value, found
+}
+
+// update stores the value returned by f for the instance with the key. f
+// gets the current value and whether it is found. It returns the new value.
+func (s *instanceStore[K, V]) update(
+	instance types.NamespacedName, key K, f func(value V, found bool) V,
+) V {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	entries, found := s.entries[instance]
+	if !found {
+		entries = map[K]V{}
+		s.entries[instance] = entries
+	}
+	value, found := entries[key]
+	value = f(value, found)
+	entries[key] = value
+	return value
+}
+
+// replace stores the value for the instance with the key and drops every
+// other value of the instance
+func (s *instanceStore[K, V]) replace(instance types.NamespacedName, key K, value V) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	s.entries[instance] = map[K]V{key: value}
+}
+
+// deleteIf drops the values of the instance whose key matches
+func (s *instanceStore[K, V]) deleteIf(instance types.NamespacedName, match func(key K) bool) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	for key := range s.entries[instance] {
+		if match(key) {
+			delete(s.entries[instance], key)
+		}
+	}
+	if len(s.entries[instance]) == 0 {
+		delete(s.entries, instance)
+	}
+}
+
+// forget drops every value of the instance, e.g. when it is deleted
+func (s *instanceStore[K, V]) forget(instance types.NamespacedName) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	delete(s.entries, instance)
+}
+
+// instanceForgetter is implemented by every instanceStore so the Handler can
+// drop the state of a deleted instance from all of them at once
+type instanceForgetter interface {
+	forget(instance types.NamespacedName)
+}
@@ -0,0 +1,63 @@
+package reconcile
+
+import (
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+
+	. "github.com/onsi/gomega"
+)
+
+func TestInstanceStore(t *testing.T) {
+	g := NewWithT(t)
+	s := newInstanceStore[string, int]()
+	pod1 := testInstanceName
+	pod2 := testInstanceName
+	pod2.Name = "other"
+
+	_, found := s.get(pod1, "a")
+	g.Expect(found).To(BeFalse())
+
+	inc := func(value int, _ bool) int { return value + 1 }
+	g.Expect(s.update(pod1, "a", inc)).To(Equal(1))
+	g.Expect(s.update(pod1, "a", inc)).To(Equal(2))
+	g.Expect(s.update(pod1, "b", inc)).To(Equal(1))
+	g.Expect(s.update(pod2, "a", inc)).To(Equal(1))
+
+	s.deleteIf(pod1, func(key string) bool { return key == "a" })
+	_, found = s.get(pod1, "a")
+	g.Expect(found).To(BeFalse())
+	value, _ := s.get(pod1, "b")
+	g.Expect(value).To(Equal(1))
+
+	s.replace(pod1, "c", 5)
+	_, found = s.get(pod1, "b")
+	g.Expect(found).To(BeFalse())
+	value, _ = s.get(pod1, "c")
+	g.Expect(value).To(Equal(5))
+
+	s.forget(pod1)
+	g.Expect(s.entries).NotTo(HaveKey(pod1))
+	value, _ = s.get(pod2, "a")
+	g.Expect(value).To(Equal(1))
+}
+
+func TestDeletedInstanceIsForgotten(t *testing.T) {
+	g := NewWithT(t)
+	c := newTestClient(newTestInstance())
+	handler, err := NewReqHandler[*corev1.Pod, *TestReq]().
+		WithSteps(FuncStep{name: "step1", do: func(r *TestReq) Result {
+			return r.RequeueWithBackoff("waiting", "key")
+		}}).
+		Build()
+	g.Expect(err).NotTo(HaveOccurred())
+
+	g.Expect(handler.handleReqWithReport(newTestReq(c), &Report{}).IsRequeue()).To(BeTrue())
+	g.Expect(handler.backoff.entries).To(HaveKey(testInstanceName))
+	g.Expect(handler.stepLogs.entries).To(HaveKey(testInstanceName))
+
+	// the instance is gone
+	g.Expect(handler.handleReqWithReport(newTestReq(newTestClient()), &Report{}).IsOK()).To(BeTrue())
+	g.Expect(handler.backoff.entries).NotTo(HaveKey(testInstanceName))
+	g.Expect(handler.stepLogs.entries).NotTo(HaveKey(testInstanceName))
+}
@@ -0,0 +1,39 @@
+package reconcile
+
+import (
+	"github.com/go-logr/logr"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// StepInfo identifies the phase of the step an Interceptor is called for
+type StepInfo struct {
+	// Step is the name of the step
+	Step string
+	// Phase is the name of the phase, e.g. Do, Post or Cleanup
+	Phase string
+}
+
+// StepFunc is the function implementing a phase of a step, e.g. Step.Do
+type StepFunc[T client.Object, R Req[T]] func(r R, log logr.Logger) Result
+
+// Interceptor wraps the call of every phase of every step. It needs to call
+// next to run the phase of the step, or the next Interceptor, and it can
+// act before and after it, or return a different Result. Interceptors run
+// within the timeout and the panic recovery of the step.
+// See ReqHandlerBuilder.WithInterceptors().
+type Interceptor[T client.Object, R Req[T]] func(
+	r R, step StepInfo, log logr.Logger, next StepFunc[T, R],
+) Result
+
+// intercept returns the stepF wrapped by the interceptors so the first
+// interceptor is called first
+func (h *Handler[T, R]) intercept(step StepInfo, stepF StepFunc[T, R]) StepFunc[T, R] {
+	for i := len(h.interceptors) - 1; i >= 0; i-- {
+		interceptor := h.interceptors[i]
+		next := stepF
+		stepF = func(r R, log logr.Logger) Result {
+			return interceptor(r, step, log, next)
+		}
+	}
+	return stepF
+}
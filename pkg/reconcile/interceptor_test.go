@@ -0,0 +1,97 @@
+package reconcile
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/go-logr/logr"
+	corev1 "k8s.io/api/core/v1"
+
+	. "github.com/onsi/gomega"
+)
+
+func recordingInterceptor(name string, calls *[]string) Interceptor[*corev1.Pod, *TestReq] {
+	return func(
+		r *TestReq, step StepInfo, log logr.Logger, next StepFunc[*corev1.Pod, *TestReq],
+	) Result {
+		*calls = append(*calls, name+" before "+step.Phase+" "+step.Step)
+		result := next(r, log)
+		*calls = append(*calls, name+" after "+step.Phase+" "+step.Step+": "+result.String())
+		return result
+	}
+}
+
+func TestInterceptorsWrapEveryPhase(t *testing.T) {
+	g := NewWithT(t)
+	c := newTestClient(newTestInstance())
+	calls := []string{}
+	handler, err := NewReqHandler[*corev1.Pod, *TestReq]().
+		WithSteps(FuncStep{name: "step1", do: func(r *TestReq) Result {
+			calls = append(calls, "Do step1")
+			return r.OK()
+		}}).
+		WithInterceptors(recordingInterceptor("outer", &calls)).
+		WithInterceptors(recordingInterceptor("inner", &calls)).
+		Build()
+	g.Expect(err).NotTo(HaveOccurred())
+
+	g.Expect(handler.handleReqWithReport(newTestReq(c), &Report{}).IsOK()).To(BeTrue())
+
+	g.Expect(calls).To(Equal([]string{
+		"outer before Do step1",
+		"inner before Do step1",
+		"Do step1",
+		"inner after Do step1: Succeeded",
+		"outer after Do step1: Succeeded",
+		"outer before Post step1",
+		"inner before Post step1",
+		"inner after Post step1: Succeeded",
+		"outer after Post step1: Succeeded",
+	}))
+}
+
+func TestInterceptorCanSkipStep(t *testing.T) {
+	g := NewWithT(t)
+	c := newTestClient(newTestInstance())
+	doRun := false
+	handler, err := NewReqHandler[*corev1.Pod, *TestReq]().
+		WithSteps(FuncStep{name: "step1", do: func(r *TestReq) Result {
+			doRun = true
+			return r.OK()
+		}}).
+		WithInterceptors(func(
+			r *TestReq, step StepInfo, log logr.Logger, next StepFunc[*corev1.Pod, *TestReq],
+		) Result {
+			if step.Phase == "Do" {
+				return r.Error(errors.New("denied by policy"), log)
+			}
+			return next(r, log)
+		}).
+		Build()
+	g.Expect(err).NotTo(HaveOccurred())
+
+	result := handler.handleReqWithReport(newTestReq(c), &Report{})
+
+	g.Expect(result.Err()).To(MatchError("denied by policy"))
+	g.Expect(doRun).To(BeFalse())
+}
+
+func TestPanicInInterceptorIsRecovered(t *testing.T) {
+	g := NewWithT(t)
+	c := newTestClient(newTestInstance())
+	handler, err := NewReqHandler[*corev1.Pod, *TestReq]().
+		WithSteps(FuncStep{name: "step1"}).
+		WithInterceptors(func(
+			r *TestReq, step StepInfo, log logr.Logger, next StepFunc[*corev1.Pod, *TestReq],
+		) Result {
+			panic("boom")
+		}).
+		Build()
+	g.Expect(err).NotTo(HaveOccurred())
+
+	result := handler.handleReqWithReport(newTestReq(c), &Report{})
+
+	var panicErr *PanicError
+	g.Expect(errors.As(result.Err(), &panicErr)).To(BeTrue())
+	g.Expect(panicErr.Step).To(Equal("step1"))
+}
@@ -0,0 +1,192 @@
+package reconcile
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/go-logr/logr"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	. "github.com/onsi/gomega"
+)
+
+// LifecycleStep records the phases it is called in
+type LifecycleStep struct {
+	FuncStep
+	calls *[]string
+	fail  string
+}
+
+func (s LifecycleStep) record(r *TestReq, phase string) Result {
+	*s.calls = append(*s.calls, s.name+"."+phase)
+	if s.fail == phase {
+		return r.Error(errors.New(phase+" failed"), r.GetLog())
+	}
+	return r.OK()
+}
+
+func (s LifecycleStep) Init(r *TestReq, log logr.Logger) Result {
+	return s.record(r, "Init")
+}
+
+func (s LifecycleStep) PreDelete(r *TestReq, log logr.Logger) Result {
+	return s.record(r, "PreDelete")
+}
+
+func (s LifecycleStep) Cleanup(r *TestReq, log logr.Logger) Result {
+	return s.record(r, "Cleanup")
+}
+
+func (s LifecycleStep) Finalize(r *TestReq, log logr.Logger) Result {
+	return s.record(r, "Finalize")
+}
+
+func (s LifecycleStep) Do(r *TestReq, log logr.Logger) Result {
+	return s.record(r, "Do")
+}
+
+func newLifecycleSteps(calls *[]string, fail string) []TestStep {
+	return []TestStep{
+		LifecycleStep{FuncStep: FuncStep{name: "step1"}, calls: calls},
+		LifecycleStep{FuncStep: FuncStep{name: "step2"}, calls: calls, fail: fail},
+	}
+}
+
+func TestInitRunsBeforeFinalizerIsAdded(t *testing.T) {
+	g := NewWithT(t)
+	instance := newTestInstance()
+	instance.Finalizers = nil
+	c := newTestClient(instance)
+	calls := []string{}
+	handler, err := NewReqHandler[*corev1.Pod, *TestReq]().
+		WithSteps(newLifecycleSteps(&calls, "")...).
+		Build()
+	g.Expect(err).NotTo(HaveOccurred())
+
+	g.Expect(handler.handleReqWithReport(newTestReq(c), &Report{}).IsOK()).To(BeTrue())
+	g.Expect(handler.handleReqWithReport(newTestReq(c), &Report{}).IsOK()).To(BeTrue())
+
+	// Init only runs once
+	g.Expect(calls).To(Equal([]string{
+		"step1.Init", "step2.Init", "step1.Do", "step2.Do", "step1.Do", "step2.Do"}))
+}
+
+func TestFailedInitDoesNotAddFinalizer(t *testing.T) {
+	g := NewWithT(t)
+	instance := newTestInstance()
+	instance.Finalizers = nil
+	c := newTestClient(instance)
+	calls := []string{}
+	handler, err := NewReqHandler[*corev1.Pod, *TestReq]().
+		WithSteps(newLifecycleSteps(&calls, "Init")...).
+		Build()
+	g.Expect(err).NotTo(HaveOccurred())
+
+	g.Expect(handler.handleReqWithReport(newTestReq(c), &Report{}).IsError()).To(BeTrue())
+
+	g.Expect(calls).To(Equal([]string{"step1.Init", "step2.Init"}))
+	g.Expect(c.Get(ctx, testInstanceName, instance)).To(Succeed())
+	g.Expect(instance.Finalizers).To(BeEmpty())
+}
+
+func TestDeletionPhases(t *testing.T) {
+	g := NewWithT(t)
+	instance := newTestInstance()
+	now := metav1.Now()
+	instance.DeletionTimestamp = &now
+	instance.Finalizers = append(instance.Finalizers, "other")
+	c := newTestClient(instance)
+	calls := []string{}
+	handler, err := NewReqHandler[*corev1.Pod, *TestReq]().
+		WithSteps(newLifecycleSteps(&calls, "")...).
+		Build()
+	g.Expect(err).NotTo(HaveOccurred())
+
+	g.Expect(handler.handleReqWithReport(newTestReq(c), &Report{}).IsOK()).To(BeTrue())
+
+	g.Expect(calls).To(Equal([]string{
+		"step1.PreDelete", "step2.PreDelete",
+		"step2.Cleanup", "step1.Cleanup",
+		"step2.Finalize", "step1.Finalize",
+	}))
+	g.Expect(c.Get(ctx, testInstanceName, instance)).To(Succeed())
+	g.Expect(instance.Finalizers).To(Equal([]string{"other"}))
+}
+
+func TestFailedFinalizeIsNotRetried(t *testing.T) {
+	g := NewWithT(t)
+	instance := newTestInstance()
+	now := metav1.Now()
+	instance.DeletionTimestamp = &now
+	instance.Finalizers = append(instance.Finalizers, "other")
+	c := newTestClient(instance)
+	calls := []string{}
+	handler, err := NewReqHandler[*corev1.Pod, *TestReq]().
+		WithSteps(newLifecycleSteps(&calls, "Finalize")...).
+		Build()
+	g.Expect(err).NotTo(HaveOccurred())
+
+	_, report, err := handler.HandleWithReport(newTestReq(c))
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(report.Result.IsOK()).To(BeTrue())
+
+	// the failure of step2 does not stop the Finalize of step1
+	g.Expect(calls).To(Equal([]string{
+		"step1.PreDelete", "step2.PreDelete",
+		"step2.Cleanup", "step1.Cleanup",
+		"step2.Finalize", "step1.Finalize",
+	}))
+	finalize, found := report.GetStep("Finalize", "step2")
+	g.Expect(found).To(BeTrue())
+	g.Expect(finalize.Result.IsError()).To(BeTrue())
+}
+
+func TestFailedPreDeleteBlocksCleanup(t *testing.T) {
+	g := NewWithT(t)
+	instance := newTestInstance()
+	now := metav1.Now()
+	instance.DeletionTimestamp = &now
+	c := newTestClient(instance)
+	calls := []string{}
+	handler, err := NewReqHandler[*corev1.Pod, *TestReq]().
+		WithSteps(newLifecycleSteps(&calls, "PreDelete")...).
+		Build()
+	g.Expect(err).NotTo(HaveOccurred())
+
+	g.Expect(handler.handleReqWithReport(newTestReq(c), &Report{}).IsError()).To(BeTrue())
+
+	g.Expect(calls).To(Equal([]string{"step1.PreDelete", "step2.PreDelete"}))
+	g.Expect(c.Get(ctx, testInstanceName, instance)).To(Succeed())
+	g.Expect(instance.Finalizers).To(Equal([]string{"pod-finalizer"}))
+}
+
+// InitStep changes the instance in its Init
+type InitStep struct {
+	FuncStep
+}
+
+func (s InitStep) Init(r *TestReq, log logr.Logger) Result {
+	r.GetInstance().Labels = map[string]string{"initialized": "true"}
+	r.GetInstance().Status.Reason = "Initialized"
+	return r.OK()
+}
+
+func TestInitChangesArePersisted(t *testing.T) {
+	g := NewWithT(t)
+	instance := newTestInstance()
+	instance.Finalizers = nil
+	c := newTestClient(instance)
+	handler, err := NewReqHandler[*corev1.Pod, *TestReq]().
+		WithSteps(InitStep{FuncStep: FuncStep{name: "step1"}}).
+		Build()
+	g.Expect(err).NotTo(HaveOccurred())
+
+	g.Expect(handler.handleReqWithReport(newTestReq(c), &Report{}).IsOK()).To(BeTrue())
+
+	instance = &corev1.Pod{}
+	g.Expect(c.Get(ctx, testInstanceName, instance)).To(Succeed())
+	g.Expect(instance.Finalizers).To(ConsistOf("pod-finalizer"))
+	g.Expect(instance.Labels).To(HaveKeyWithValue("initialized", "true"))
+	g.Expect(instance.Status.Reason).To(Equal("Initialized"))
+}
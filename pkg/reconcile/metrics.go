@@ -0,0 +1,93 @@
+package reconcile
+
+import (
+	"reflect"
+	"strings"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/metrics"
+)
+
+// The outcome label values of the StepResultsMetric
+const (
+	OutcomeOK       = "ok"
+	OutcomeRequeue  = "requeue"
+	OutcomeError    = "error"
+	OutcomeTerminal = "terminal"
+)
+
+// The names of the metrics registered in the controller-runtime metrics
+// registry
+const (
+	StepDurationMetric = "okofw_step_duration_seconds"
+	StepResultsMetric  = "okofw_step_results_total"
+	SaveDurationMetric = "okofw_save_duration_seconds"
+)
+
+var (
+	stepDuration = prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Name: StepDurationMetric,
+			Help: "Duration of a phase of a reconcile step in seconds",
+		},
+		[]string{"controller", "step", "phase"},
+	)
+	stepResults = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: StepResultsMetric,
+			Help: "Number of the results of the phases of the reconcile steps by outcome",
+		},
+		[]string{"controller", "step", "phase", "outcome"},
+	)
+	saveDuration = prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Name: SaveDurationMetric,
+			Help: "Duration of the patch requests persisting the instance in seconds",
+		},
+		[]string{"controller", "part"},
+	)
+)
+
+func init() {
+	metrics.Registry.MustRegister(stepDuration, stepResults, saveDuration)
+}
+
+// outcome returns the value of the outcome label of the result
+func outcome(result Result) string {
+	switch {
+	case result.IsTerminal():
+		return OutcomeTerminal
+	case result.IsError():
+		return OutcomeError
+	case result.IsRequeue():
+		return OutcomeRequeue
+	default:
+		return OutcomeOK
+	}
+}
+
+// observeStep records the duration and the result of a phase of a step
+func observeStep(
+	controller string, phase string, name string, duration time.Duration, result Result,
+) {
+	stepDuration.WithLabelValues(controller, name, phase).Observe(duration.Seconds())
+	stepResults.WithLabelValues(controller, name, phase, outcome(result)).Inc()
+}
+
+// observeSave records the duration of a patch request persisting the part
+// of the instance
+func observeSave(controller string, part string, duration time.Duration) {
+	saveDuration.WithLabelValues(controller, part).Observe(duration.Seconds())
+}
+
+// defaultControllerName returns the lower case kind of T, the same as the
+// default name of the controller in controller-runtime
+func defaultControllerName[T client.Object]() string {
+	typ := reflect.TypeOf((*T)(nil)).Elem()
+	for typ.Kind() == reflect.Pointer {
+		typ = typ.Elem()
+	}
+	return strings.ToLower(typ.Name())
+}
@@ -0,0 +1,109 @@
+package reconcile
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+
+	dto "github.com/prometheus/client_model/go"
+	corev1 "k8s.io/api/core/v1"
+	"sigs.k8s.io/controller-runtime/pkg/metrics"
+
+	. "github.com/onsi/gomega"
+)
+
+// getMetric returns the metric with the given name and labels from the
+// controller-runtime metrics registry or nil if it is not found
+func getMetric(g *WithT, name string, labels map[string]string) *dto.Metric {
+	families, err := metrics.Registry.Gather()
+	g.Expect(err).NotTo(HaveOccurred())
+	for _, family := range families {
+		if family.GetName() != name {
+			continue
+		}
+		for _, metric := range family.GetMetric() {
+			if hasLabels(metric, labels) {
+				return metric
+			}
+		}
+	}
+	return nil
+}
+
+func hasLabels(metric *dto.Metric, labels map[string]string) bool {
+	found := 0
+	for _, label := range metric.GetLabel() {
+		if value, ok := labels[label.GetName()]; ok {
+			if value != label.GetValue() {
+				return false
+			}
+			found++
+		}
+	}
+	return found == len(labels)
+}
+
+// metricsTestRuns makes the controller name unique per test run as the
+// metrics registry is global and kept between runs, e.g. with -count=2
+var metricsTestRuns = 0
+
+func TestStepMetricsRecorded(t *testing.T) {
+	g := NewWithT(t)
+	metricsTestRuns++
+	controllerName := fmt.Sprintf("metrics-test-%d", metricsTestRuns)
+	c := newTestClient(newTestInstance())
+	handler, err := NewReqHandler[*corev1.Pod, *TestReq]().
+		WithSteps(
+			FuncStep{name: "step1", do: func(r *TestReq) Result {
+				r.GetInstance().Status.Reason = "foo"
+				return r.OK()
+			}},
+			FuncStep{name: "step2", do: func(r *TestReq) Result {
+				return r.Error(errors.New("boom"), r.GetLog())
+			}},
+		).
+		WithControllerName(controllerName).
+		Build()
+	g.Expect(err).NotTo(HaveOccurred())
+
+	handler.handleReqWithReport(newTestReq(c), &Report{})
+
+	step1 := map[string]string{"controller": controllerName, "step": "step1", "phase": "Do"}
+	duration := getMetric(g, StepDurationMetric, step1)
+	g.Expect(duration).NotTo(BeNil())
+	g.Expect(duration.GetHistogram().GetSampleCount()).To(Equal(uint64(1)))
+
+	step1["outcome"] = OutcomeOK
+	g.Expect(getMetric(g, StepResultsMetric, step1).GetCounter().GetValue()).To(Equal(1.0))
+
+	step2 := map[string]string{
+		"controller": controllerName, "step": "step2", "phase": "Do", "outcome": OutcomeError}
+	g.Expect(getMetric(g, StepResultsMetric, step2).GetCounter().GetValue()).To(Equal(1.0))
+
+	post := map[string]string{
+		"controller": controllerName, "step": "step2", "phase": "Post", "outcome": OutcomeOK}
+	g.Expect(getMetric(g, StepResultsMetric, post).GetCounter().GetValue()).To(Equal(1.0))
+
+	// only the status is changed so only that is patched
+	status := getMetric(g, SaveDurationMetric, map[string]string{
+		"controller": controllerName, "part": "status"})
+	g.Expect(status).NotTo(BeNil())
+	g.Expect(status.GetHistogram().GetSampleCount()).To(Equal(uint64(1)))
+	g.Expect(getMetric(g, SaveDurationMetric, map[string]string{
+		"controller": controllerName, "part": "instance"})).To(BeNil())
+}
+
+func TestOutcome(t *testing.T) {
+	g := NewWithT(t)
+	r := newTestReq(newTestClient())
+
+	g.Expect(outcome(r.OK())).To(Equal(OutcomeOK))
+	g.Expect(outcome(r.Requeue("wait"))).To(Equal(OutcomeRequeue))
+	g.Expect(outcome(r.Error(errors.New("boom"), r.GetLog()))).To(Equal(OutcomeError))
+	g.Expect(outcome(r.Terminal(errors.New("boom")))).To(Equal(OutcomeTerminal))
+}
+
+func TestDefaultControllerName(t *testing.T) {
+	g := NewWithT(t)
+	g.Expect(defaultControllerName[*corev1.Pod]()).To(Equal("pod"))
+}
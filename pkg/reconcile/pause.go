@@ -0,0 +1,20 @@
+package reconcile
+
+import (
+	"strconv"
+
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// PausedAnnotation pauses the reconciliation of the CR if its value is
+// "true". While paused the Do and Cleanup of the steps are not run, only
+// Post, and the ReconcileActiveCondition is set on the CR. The
+// reconciliation resumes when the annotation is removed.
+const PausedAnnotation = "okofw.openstack.org/paused"
+
+// isPaused returns true if the reconciliation of the instance is paused via
+// the PausedAnnotation
+func isPaused(instance client.Object) bool {
+	paused, err := strconv.ParseBool(instance.GetAnnotations()[PausedAnnotation])
+	return err == nil && paused
+}
@@ -0,0 +1,130 @@
+package reconcile
+
+import (
+	"testing"
+
+	"github.com/openstack-k8s-operators/lib-common/modules/common/condition"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	. "github.com/onsi/gomega"
+)
+
+func newPauseTestSteps(calls *[]string) []TestStep {
+	record := func(phase string) func(r *TestReq) Result {
+		return func(r *TestReq) Result {
+			*calls = append(*calls, phase)
+			return r.OK()
+		}
+	}
+	return []TestStep{
+		FuncStep{name: "step1", do: record("Do"), cleanup: record("Cleanup"), post: record("Post")},
+	}
+}
+
+func TestPausedInstanceIsNotReconciled(t *testing.T) {
+	g := NewWithT(t)
+	instance := newTestInstance()
+	instance.Finalizers = nil
+	instance.Annotations = map[string]string{PausedAnnotation: "true"}
+	c := newTestClient(instance)
+	calls := []string{}
+	handler, err := NewReqHandler[*corev1.Pod, *TestReq]().
+		WithSteps(newPauseTestSteps(&calls)...).
+		Build()
+	g.Expect(err).NotTo(HaveOccurred())
+
+	g.Expect(handler.handleReqWithReport(newTestReq(c), &Report{}).IsOK()).To(BeTrue())
+
+	g.Expect(calls).To(Equal([]string{"Post"}))
+	g.Expect(c.Get(ctx, testInstanceName, instance)).To(Succeed())
+	g.Expect(instance.Finalizers).To(BeEmpty())
+
+	// resume
+	instance.Annotations = nil
+	g.Expect(c.Update(ctx, instance)).To(Succeed())
+	calls = []string{}
+
+	g.Expect(handler.handleReqWithReport(newTestReq(c), &Report{}).IsOK()).To(BeTrue())
+
+	g.Expect(calls).To(Equal([]string{"Do", "Post"}))
+	g.Expect(c.Get(ctx, testInstanceName, instance)).To(Succeed())
+	g.Expect(instance.Finalizers).To(Equal([]string{"pod-finalizer"}))
+}
+
+func TestPausedInstanceIsDeletedByDefault(t *testing.T) {
+	g := NewWithT(t)
+	instance := newTestInstance()
+	now := metav1.Now()
+	instance.DeletionTimestamp = &now
+	instance.Finalizers = append(instance.Finalizers, "other")
+	instance.Annotations = map[string]string{PausedAnnotation: "true"}
+	c := newTestClient(instance)
+	calls := []string{}
+	handler, err := NewReqHandler[*corev1.Pod, *TestReq]().
+		WithSteps(newPauseTestSteps(&calls)...).
+		Build()
+	g.Expect(err).NotTo(HaveOccurred())
+
+	g.Expect(handler.handleReqWithReport(newTestReq(c), &Report{}).IsOK()).To(BeTrue())
+
+	g.Expect(calls).To(Equal([]string{"Cleanup", "Post"}))
+	g.Expect(c.Get(ctx, testInstanceName, instance)).To(Succeed())
+	g.Expect(instance.Finalizers).To(Equal([]string{"other"}))
+}
+
+func TestPausedDeletionBlocked(t *testing.T) {
+	g := NewWithT(t)
+	instance := newTestInstance()
+	now := metav1.Now()
+	instance.DeletionTimestamp = &now
+	instance.Annotations = map[string]string{PausedAnnotation: "true"}
+	c := newTestClient(instance)
+	calls := []string{}
+	handler, err := NewReqHandler[*corev1.Pod, *TestReq]().
+		WithSteps(newPauseTestSteps(&calls)...).
+		WithPausedDeletionBlocked().
+		Build()
+	g.Expect(err).NotTo(HaveOccurred())
+
+	g.Expect(handler.handleReqWithReport(newTestReq(c), &Report{}).IsOK()).To(BeTrue())
+
+	g.Expect(calls).To(Equal([]string{"Post"}))
+	g.Expect(c.Get(ctx, testInstanceName, instance)).To(Succeed())
+	g.Expect(instance.Finalizers).To(Equal([]string{"pod-finalizer"}))
+}
+
+func TestPausedAnnotationValues(t *testing.T) {
+	g := NewWithT(t)
+	instance := newTestInstance()
+	g.Expect(isPaused(instance)).To(BeFalse())
+
+	instance.Annotations = map[string]string{PausedAnnotation: "false"}
+	g.Expect(isPaused(instance)).To(BeFalse())
+
+	instance.Annotations = map[string]string{PausedAnnotation: "yes please"}
+	g.Expect(isPaused(instance)).To(BeFalse())
+
+	instance.Annotations = map[string]string{PausedAnnotation: "true"}
+	g.Expect(isPaused(instance)).To(BeTrue())
+}
+
+func TestReconcileActiveCondition(t *testing.T) {
+	g := NewWithT(t)
+	instance := &PodWithConditions{}
+	snapshot := &PodWithConditions{}
+	instance.SetConditions(condition.Conditions{})
+	snapshot.SetConditions(condition.Conditions{})
+
+	markReconcilePaused(instance, snapshot)
+	conditions := instance.GetConditions()
+	cond := conditions.Get(ReconcileActiveCondition)
+	g.Expect(cond).NotTo(BeNil())
+	g.Expect(cond.Status).To(Equal(corev1.ConditionFalse))
+	g.Expect(cond.Reason).To(Equal(ReconcilePausedReason))
+	g.Expect(cond.Message).To(ContainSubstring(PausedAnnotation))
+
+	clearReconcilePaused(instance)
+	conditions = instance.GetConditions()
+	g.Expect(conditions.Has(ReconcileActiveCondition)).To(BeFalse())
+}
@@ -0,0 +1,517 @@
+package reconcile
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"reflect"
+	"strings"
+
+	jsonmergepatch "github.com/evanphx/json-patch/v5"
+	"gomodules.xyz/jsonpatch/v2"
+	"k8s.io/apimachinery/pkg/api/equality"
+	k8s_errors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/utils/pointer"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/apiutil"
+	"sigs.k8s.io/structured-merge-diff/v4/fieldpath"
+)
+
+// PersistenceStrategy defines how the Handler persists the instance at the
+// end of the reconciliation. Both functions get the instance as modified by
+// the steps and the snapshot of the instance taken right after it was read.
+// The Handler only calls them if the respective part of the instance differs
+// from the snapshot.
+type PersistenceStrategy[T client.Object] interface {
+	// PatchInstance persists the changes of the instance except the status.
+	// It must not change the status of the passed in instance.
+	PatchInstance(ctx context.Context, c client.Client, instance T, snapshot T) error
+	// PatchStatus persists the changes of the status subresource of the
+	// instance.
+	PatchStatus(ctx context.Context, c client.Client, instance T, snapshot T) error
+}
+
+// MergePatch persists the instance via JSON merge patches calculated
+// between the snapshot and the instance. This is the default strategy of the
+// Handler.
+type MergePatch[T client.Object] struct {
+	// OptimisticLock adds the resourceVersion of the snapshot to the patches
+	// so the API server rejects them with a Conflict error if the instance
+	// was changed by somebody else since it was read.
+	OptimisticLock bool
+}
+
+func (s MergePatch[T]) PatchInstance(
+	ctx context.Context, c client.Client, instance T, snapshot T,
+) error {
+	// We need to pass a copy to Patch as it will reset the Status fields by
+	// reading back the object after Patching the non status part.
+	obj := instance.DeepCopyObject().(T)
+	err := c.Patch(ctx, obj, s.patchFrom(snapshot, snapshot.GetResourceVersion()))
+	if err != nil {
+		return err
+	}
+	if s.OptimisticLock {
+		// Our own patch changed the resourceVersion so the status patch
+		// needs to be based on the new one
+		instance.SetResourceVersion(obj.GetResourceVersion())
+	}
+	return nil
+}
+
+func (s MergePatch[T]) PatchStatus(
+	ctx context.Context, c client.Client, instance T, snapshot T,
+) error {
+	return c.Status().Patch(
+		ctx, instance, s.patchFrom(snapshot, instance.GetResourceVersion()))
+}
+
+func (s MergePatch[T]) patchFrom(base T, resourceVersion string) client.Patch {
+	if !s.OptimisticLock {
+		return client.MergeFrom(base)
+	}
+	base = base.DeepCopyObject().(T)
+	base.SetResourceVersion(resourceVersion)
+	return client.MergeFromWithOptions(base, client.MergeFromWithOptimisticLock{})
+}
+
+// JSONPatch persists the instance via JSON patches (RFC 6902) calculated
+// between the snapshot and the instance. Each patch also sets the
+// resourceVersion the instance had when it was read, so the API server
+// rejects the patch with a Conflict error if the instance was changed by
+// somebody else in the meantime.
+type JSONPatch[T client.Object] struct{}
+
+func (s JSONPatch[T]) PatchInstance(
+	ctx context.Context, c client.Client, instance T, snapshot T,
+) error {
+	ops, err := diff(snapshot, instance, isNotStatusPath)
+	if err != nil {
+		return err
+	}
+	patch, err := jsonPatchWithLock(ops, snapshot.GetResourceVersion())
+	if err != nil {
+		return err
+	}
+	obj := instance.DeepCopyObject().(T)
+	err = c.Patch(ctx, obj, client.RawPatch(types.JSONPatchType, patch))
+	if err != nil {
+		return err
+	}
+	// Our own patch changed the resourceVersion so the status patch needs
+	// to be based on the new one
+	instance.SetResourceVersion(obj.GetResourceVersion())
+	return nil
+}
+
+func (s JSONPatch[T]) PatchStatus(
+	ctx context.Context, c client.Client, instance T, snapshot T,
+) error {
+	ops, err := diff(snapshot, instance, isStatusPath)
+	if err != nil {
+		return err
+	}
+	patch, err := jsonPatchWithLock(ops, instance.GetResourceVersion())
+	if err != nil {
+		return err
+	}
+	return c.Status().Patch(ctx, instance, client.RawPatch(types.JSONPatchType, patch))
+}
+
+func isStatusPath(path string) bool {
+	return path == "/status" || strings.HasPrefix(path, "/status/")
+}
+
+func isNotStatusPath(path string) bool {
+	return !isStatusPath(path)
+}
+
+// diff returns the JSON patch operations transforming from to to, limited
+// to the paths accepted by the filter
+func diff(
+	from client.Object, to client.Object, filter func(path string) bool,
+) ([]jsonpatch.Operation, error) {
+	fromJSON, err := json.Marshal(from)
+	if err != nil {
+		return nil, err
+	}
+	toJSON, err := json.Marshal(to)
+	if err != nil {
+		return nil, err
+	}
+	ops, err := jsonpatch.CreatePatch(fromJSON, toJSON)
+	if err != nil {
+		return nil, err
+	}
+
+	filtered := []jsonpatch.Operation{}
+	for _, op := range ops {
+		if filter(op.Path) {
+			filtered = append(filtered, op)
+		}
+	}
+	return filtered, nil
+}
+
+// jsonPatchWithLock returns the JSON patch of the operations prefixed with
+// an operation setting the resourceVersion
+func jsonPatchWithLock(
+	ops []jsonpatch.Operation, resourceVersion string,
+) ([]byte, error) {
+	patch := []jsonpatch.Operation{
+		jsonpatch.NewOperation("replace", "/metadata/resourceVersion", resourceVersion),
+	}
+	return json.Marshal(append(patch, ops...))
+}
+
+// ServerSideApply persists the instance via server-side apply with
+// FieldManager as the field owner. Only the fields the operator owns are
+// applied: the fields the steps changed during the reconciliation and the
+// fields previously applied by the same FieldManager according to the
+// managedFields of the instance. So fields set only by other writers (users,
+// other controllers) are not clobbered. A field removed by the steps is
+// left out from the apply if it is owned by FieldManager, otherwise it is
+// removed by an additional JSON merge patch.
+type ServerSideApply[T client.Object] struct {
+	// FieldManager identifies the operator as the owner of the applied
+	// fields
+	FieldManager string
+	// Force takes the ownership of the applied fields even if they are
+	// owned by other field managers. Without it such apply fails with a
+	// Conflict error.
+	Force bool
+}
+
+func (s ServerSideApply[T]) PatchInstance(
+	ctx context.Context, c client.Client, instance T, snapshot T,
+) error {
+	obj, err := s.applyConfiguration(c, instance, snapshot, "")
+	if err != nil {
+		return err
+	}
+	opts := []client.PatchOption{client.FieldOwner(s.FieldManager)}
+	if s.Force {
+		opts = append(opts, client.ForceOwnership)
+	}
+	err = c.Patch(ctx, obj, client.Apply, opts...)
+	if err != nil {
+		return err
+	}
+	return s.removeFields(ctx, c, instance, snapshot, "")
+}
+
+func (s ServerSideApply[T]) PatchStatus(
+	ctx context.Context, c client.Client, instance T, snapshot T,
+) error {
+	obj, err := s.applyConfiguration(c, instance, snapshot, "status")
+	if err != nil {
+		return err
+	}
+	opts := &client.SubResourcePatchOptions{
+		PatchOptions: client.PatchOptions{FieldManager: s.FieldManager},
+	}
+	if s.Force {
+		opts.Force = pointer.Bool(true)
+	}
+	err = c.Status().Patch(ctx, obj, client.Apply, opts)
+	if err != nil {
+		return err
+	}
+	return s.removeFields(ctx, c, instance, snapshot, "status")
+}
+
+// removeFields removes the fields the steps removed from the instance but
+// the FieldManager does not own, as leaving them out from the apply does not
+// remove them. They are removed via a JSON merge patch setting them to null.
+func (s ServerSideApply[T]) removeFields(
+	ctx context.Context, c client.Client, instance T, snapshot T, subresource string,
+) error {
+	patch, err := s.removalPatch(instance, snapshot, subresource)
+	if err != nil || patch == nil {
+		return err
+	}
+	// Patch a copy as the patch reads back the whole object
+	obj := instance.DeepCopyObject().(T)
+	if subresource == "status" {
+		return c.Status().Patch(ctx, obj, client.RawPatch(types.MergePatchType, patch))
+	}
+	return c.Patch(ctx, obj, client.RawPatch(types.MergePatchType, patch))
+}
+
+// removalPatch returns the JSON merge patch removing the fields of the
+// subresource that are in the snapshot but not in the instance and are not
+// owned by the FieldManager. It returns nil if there is no such field.
+func (s ServerSideApply[T]) removalPatch(
+	instance T, snapshot T, subresource string,
+) ([]byte, error) {
+	current, err := runtime.DefaultUnstructuredConverter.ToUnstructured(instance)
+	if err != nil {
+		return nil, err
+	}
+	previous, err := runtime.DefaultUnstructuredConverter.ToUnstructured(snapshot)
+	if err != nil {
+		return nil, err
+	}
+	owned, err := ownedFields(instance.GetManagedFields(), s.FieldManager, subresource)
+	if err != nil {
+		return nil, err
+	}
+	removed := &fieldpath.Set{}
+	collectRemovedFields(previous, current, fieldpath.Path{}, removed)
+
+	patch := map[string]interface{}{}
+	removed.Iterate(func(path fieldpath.Path) {
+		isStatus := *path[0].FieldName == "status"
+		if owned.Has(path) || isStatus != (subresource == "status") {
+			return
+		}
+		fields := []string{}
+		for _, element := range path {
+			fields = append(fields, *element.FieldName)
+		}
+		_ = unstructured.SetNestedField(patch, nil, fields...)
+	})
+	if len(patch) == 0 {
+		return nil, nil
+	}
+	return json.Marshal(patch)
+}
+
+// applyConfiguration returns the object containing only the fields the
+// operator owns either in the main resource or in the status subresource.
+func (s ServerSideApply[T]) applyConfiguration(
+	c client.Client, instance T, snapshot T, subresource string,
+) (*unstructured.Unstructured, error) {
+	gvk, err := apiutil.GVKForObject(instance, c.Scheme())
+	if err != nil {
+		return nil, err
+	}
+	current, err := runtime.DefaultUnstructuredConverter.ToUnstructured(instance)
+	if err != nil {
+		return nil, err
+	}
+	previous, err := runtime.DefaultUnstructuredConverter.ToUnstructured(snapshot)
+	if err != nil {
+		return nil, err
+	}
+	owned, err := ownedFields(instance.GetManagedFields(), s.FieldManager, subresource)
+	if err != nil {
+		return nil, err
+	}
+	changed := &fieldpath.Set{}
+	collectChangedFields(previous, current, fieldpath.Path{}, changed)
+
+	obj := &unstructured.Unstructured{Object: map[string]interface{}{}}
+	owned.Union(changed).Leaves().Iterate(func(path fieldpath.Path) {
+		copyField(current, obj.Object, path)
+	})
+
+	if subresource == "status" {
+		status, found := obj.Object["status"]
+		obj.Object = map[string]interface{}{}
+		if found {
+			obj.Object["status"] = status
+		}
+	} else {
+		delete(obj.Object, "status")
+	}
+	// these are maintained by the API server and never applied
+	for _, field := range []string{
+		"managedFields", "resourceVersion", "uid", "generation",
+		"creationTimestamp", "deletionTimestamp", "deletionGracePeriodSeconds",
+	} {
+		unstructured.RemoveNestedField(obj.Object, "metadata", field)
+	}
+	obj.SetGroupVersionKind(gvk)
+	obj.SetNamespace(instance.GetNamespace())
+	obj.SetName(instance.GetName())
+	return obj, nil
+}
+
+// ownedFields returns the fields applied by the manager to the given
+// subresource ("" means the main resource).
+func ownedFields(
+	managedFields []metav1.ManagedFieldsEntry, manager string, subresource string,
+) (*fieldpath.Set, error) {
+	owned := &fieldpath.Set{}
+	for _, entry := range managedFields {
+		if entry.Manager != manager ||
+			entry.Operation != metav1.ManagedFieldsOperationApply ||
+			entry.Subresource != subresource ||
+			entry.FieldsV1 == nil {
+			continue
+		}
+		fields := &fieldpath.Set{}
+		err := fields.FromJSON(bytes.NewReader(entry.FieldsV1.Raw))
+		if err != nil {
+			return nil, err
+		}
+		owned = owned.Union(fields)
+	}
+	return owned, nil
+}
+
+// collectChangedFields adds the path of every field to changed that is added
+// or updated in current compared to previous. Maps are compared field by
+// field while other values, including lists, are compared as a whole.
+func collectChangedFields(
+	previous map[string]interface{}, current map[string]interface{},
+	prefix fieldpath.Path, changed *fieldpath.Set,
+) {
+	for name, currentValue := range current {
+		fieldName := name
+		path := append(prefix.Copy(), fieldpath.PathElement{FieldName: &fieldName})
+
+		previousValue, found := previous[name]
+		previousMap, previousIsMap := previousValue.(map[string]interface{})
+		currentMap, currentIsMap := currentValue.(map[string]interface{})
+		if found && previousIsMap && currentIsMap {
+			collectChangedFields(previousMap, currentMap, path, changed)
+			continue
+		}
+		if !found || !equality.Semantic.DeepEqual(previousValue, currentValue) {
+			changed.Insert(path)
+		}
+	}
+}
+
+// collectRemovedFields adds the path of every field to removed that is in
+// previous but not in current. Removed maps are followed so only their
+// fields are removed, not the fields added by somebody else in the meantime.
+func collectRemovedFields(
+	previous map[string]interface{}, current map[string]interface{},
+	prefix fieldpath.Path, removed *fieldpath.Set,
+) {
+	for name, previousValue := range previous {
+		fieldName := name
+		path := append(prefix.Copy(), fieldpath.PathElement{FieldName: &fieldName})
+
+		currentValue, found := current[name]
+		previousMap, previousIsMap := previousValue.(map[string]interface{})
+		currentMap, currentIsMap := currentValue.(map[string]interface{})
+		if previousIsMap && len(previousMap) > 0 && (!found || currentIsMap) {
+			collectRemovedFields(previousMap, currentMap, path, removed)
+			continue
+		}
+		if !found {
+			removed.Insert(path)
+		}
+	}
+}
+
+// copyField copies the field at path from src to dst. The path is followed
+// only through map fields, so if it points into a list then the whole list
+// is copied.
+func copyField(src map[string]interface{}, dst map[string]interface{}, path fieldpath.Path) {
+	fields := []string{}
+	for _, element := range path {
+		if element.FieldName == nil {
+			break
+		}
+		fields = append(fields, *element.FieldName)
+	}
+	if len(fields) == 0 {
+		return
+	}
+	value, found, err := unstructured.NestedFieldCopy(src, fields...)
+	if err != nil || !found {
+		// the field is removed so it is not applied anymore
+		return
+	}
+	_ = unstructured.SetNestedField(dst, value, fields...)
+}
+
+// ConflictPolicy defines what the Handler does if persisting the instance
+// fails with a Conflict error
+type ConflictPolicy string
+
+const (
+	// RequeueOnConflict returns a Conflict Result so the whole
+	// reconciliation is retried on a fresh copy of the instance.
+	RequeueOnConflict ConflictPolicy = "Requeue"
+	// RetryStatusOnConflict re-reads the instance and re-applies the status
+	// changes made by the steps if persisting the status fails with a
+	// Conflict. A conflict while persisting the rest of the instance, or a
+	// repeated conflict, is still handled by a Conflict Result.
+	RetryStatusOnConflict ConflictPolicy = "RetryStatus"
+)
+
+// maxStatusConflictRetries defines how many times the status is re-applied
+// on a fresh instance with RetryStatusOnConflict
+const maxStatusConflictRetries = 3
+
+// retryStatusPatch re-reads the instance and applies the status changes
+// the steps made on top of it until it succeeds, or a non conflict error
+// happens, or the retries are exhausted.
+func retryStatusPatch[T client.Object](
+	ctx context.Context, c client.Client, strategy PersistenceStrategy[T],
+	instance T, snapshot T,
+) error {
+	delta, err := statusDelta(snapshot, instance)
+	if err != nil {
+		return err
+	}
+
+	for i := 0; i < maxStatusConflictRetries; i++ {
+		fresh := newObject(instance)
+		err = c.Get(ctx, client.ObjectKeyFromObject(instance), fresh)
+		if err != nil {
+			return err
+		}
+		var updated T
+		updated, err = applyMergePatch(fresh, delta)
+		if err != nil {
+			return err
+		}
+		err = strategy.PatchStatus(ctx, c, updated, fresh)
+		if !k8s_errors.IsConflict(err) {
+			return err
+		}
+	}
+	return err
+}
+
+// statusDelta returns the JSON merge patch describing the changes of the
+// status from snapshot to instance.
+func statusDelta(snapshot client.Object, instance client.Object) ([]byte, error) {
+	statusOnly := func(obj client.Object) ([]byte, error) {
+		data, err := runtime.DefaultUnstructuredConverter.ToUnstructured(obj)
+		if err != nil {
+			return nil, err
+		}
+		return json.Marshal(map[string]interface{}{"status": data["status"]})
+	}
+	from, err := statusOnly(snapshot)
+	if err != nil {
+		return nil, err
+	}
+	to, err := statusOnly(instance)
+	if err != nil {
+		return nil, err
+	}
+	return jsonmergepatch.CreateMergePatch(from, to)
+}
+
+// applyMergePatch returns a copy of obj with the JSON merge patch applied
+func applyMergePatch[T client.Object](obj T, patch []byte) (T, error) {
+	original, err := json.Marshal(obj)
+	if err != nil {
+		return obj, err
+	}
+	patched, err := jsonmergepatch.MergePatch(original, patch)
+	if err != nil {
+		return obj, err
+	}
+	result := newObject(obj)
+	err = json.Unmarshal(patched, result)
+	return result, err
+}
+
+// newObject returns a new empty object with the same type as obj
+func newObject[T client.Object](obj T) T {
+	return reflect.New(reflect.TypeOf(obj).Elem()).Interface().(T)
+}
@@ -0,0 +1,407 @@
+package reconcile
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	k8s_errors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	. "github.com/onsi/gomega"
+)
+
+func TestMergePatchPersistsInstance(t *testing.T) {
+	g := NewWithT(t)
+	c := newTestClient(newTestInstance())
+	handler, err := NewReqHandler[*corev1.Pod, *TestReq]().
+		WithSteps(FuncStep{name: "step1", do: func(r *TestReq) Result {
+			r.GetInstance().Labels = map[string]string{"foo": "bar"}
+			return r.OK()
+		}}).
+		Build()
+	g.Expect(err).NotTo(HaveOccurred())
+
+	_, err = handler.Handle(newTestReq(c))
+	g.Expect(err).NotTo(HaveOccurred())
+
+	instance := &corev1.Pod{}
+	g.Expect(c.Get(ctx, testInstanceName, instance)).To(Succeed())
+	g.Expect(instance.Labels).To(HaveKeyWithValue("foo", "bar"))
+}
+
+func TestJSONPatchPersistsInstance(t *testing.T) {
+	g := NewWithT(t)
+	c := newTestClient(newTestInstance())
+	handler, err := NewReqHandler[*corev1.Pod, *TestReq]().
+		WithSteps(FuncStep{name: "step1", do: func(r *TestReq) Result {
+			r.GetInstance().Labels = map[string]string{"foo": "bar"}
+			return r.OK()
+		}}).
+		WithPersistenceStrategy(JSONPatch[*corev1.Pod]{}).
+		Build()
+	g.Expect(err).NotTo(HaveOccurred())
+
+	_, err = handler.Handle(newTestReq(c))
+	g.Expect(err).NotTo(HaveOccurred())
+
+	instance := &corev1.Pod{}
+	g.Expect(c.Get(ctx, testInstanceName, instance)).To(Succeed())
+	g.Expect(instance.Labels).To(HaveKeyWithValue("foo", "bar"))
+}
+
+func TestJSONPatchDetectsConcurrentUpdate(t *testing.T) {
+	g := NewWithT(t)
+	c := newTestClient(newTestInstance())
+	handler, err := NewReqHandler[*corev1.Pod, *TestReq]().
+		WithSteps(FuncStep{name: "step1", do: func(r *TestReq) Result {
+			// simulate that somebody else updates the instance while we
+			// reconcile it
+			other := &corev1.Pod{}
+			g.Expect(c.Get(ctx, testInstanceName, other)).To(Succeed())
+			other.Annotations = map[string]string{"other": "writer"}
+			g.Expect(c.Update(ctx, other)).To(Succeed())
+
+			r.GetInstance().Labels = map[string]string{"foo": "bar"}
+			return r.OK()
+		}}).
+		WithPersistenceStrategy(JSONPatch[*corev1.Pod]{}).
+		Build()
+	g.Expect(err).NotTo(HaveOccurred())
+
+	result, err := handler.Handle(newTestReq(c))
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(result.Requeue).To(BeTrue())
+
+	instance := &corev1.Pod{}
+	g.Expect(c.Get(ctx, testInstanceName, instance)).To(Succeed())
+	g.Expect(instance.Labels).NotTo(HaveKey("foo"))
+	g.Expect(instance.Annotations).To(HaveKeyWithValue("other", "writer"))
+}
+
+func TestServerSideApplyOnlyAppliesOwnedFields(t *testing.T) {
+	g := NewWithT(t)
+	c := newTestClient()
+	snapshot := newTestInstance()
+	snapshot.Labels = map[string]string{"ours": "1", "theirs": "1"}
+	snapshot.ManagedFields = []metav1.ManagedFieldsEntry{
+		{
+			Manager:   "okofw",
+			Operation: metav1.ManagedFieldsOperationApply,
+			FieldsV1: &metav1.FieldsV1{
+				Raw: []byte(`{"f:metadata":{"f:labels":{"f:ours":{}}}}`),
+			},
+		},
+		{
+			Manager:   "kubectl",
+			Operation: metav1.ManagedFieldsOperationUpdate,
+			FieldsV1: &metav1.FieldsV1{
+				Raw: []byte(`{"f:metadata":{"f:labels":{"f:theirs":{}}}}`),
+			},
+		},
+	}
+	instance := snapshot.DeepCopy()
+	instance.Annotations = map[string]string{"new": "value"}
+	instance.Spec.NodeName = "node1"
+
+	strategy := ServerSideApply[*corev1.Pod]{FieldManager: "okofw"}
+	obj, err := strategy.applyConfiguration(c, instance, snapshot, "")
+
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(obj.GetKind()).To(Equal("Pod"))
+	g.Expect(obj.GetName()).To(Equal(testInstanceName.Name))
+	g.Expect(obj.GetNamespace()).To(Equal(testInstanceName.Namespace))
+	g.Expect(obj.GetLabels()).To(Equal(map[string]string{"ours": "1"}))
+	g.Expect(obj.GetAnnotations()).To(Equal(map[string]string{"new": "value"}))
+	g.Expect(obj.Object).To(HaveKeyWithValue("spec", HaveKeyWithValue("nodeName", "node1")))
+	g.Expect(obj.GetManagedFields()).To(BeNil())
+}
+
+func TestServerSideApplyStatusOnlyAppliesStatus(t *testing.T) {
+	g := NewWithT(t)
+	c := newTestClient()
+	snapshot := newTestInstance()
+	instance := snapshot.DeepCopy()
+	instance.Labels = map[string]string{"foo": "bar"}
+	instance.Status.Phase = corev1.PodRunning
+
+	strategy := ServerSideApply[*corev1.Pod]{FieldManager: "okofw"}
+	obj, err := strategy.applyConfiguration(c, instance, snapshot, "status")
+
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(obj.GetLabels()).To(BeNil())
+	g.Expect(obj.Object).To(HaveKeyWithValue("status", HaveKeyWithValue("phase", "Running")))
+
+	obj, err = strategy.applyConfiguration(c, instance, snapshot, "")
+
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(obj.GetLabels()).To(Equal(map[string]string{"foo": "bar"}))
+	g.Expect(obj.Object).NotTo(HaveKey("status"))
+}
+
+func TestServerSideApplyRemovesFields(t *testing.T) {
+	g := NewWithT(t)
+	instance := newTestInstance()
+	instance.Annotations = map[string]string{"keep": "1", "remove": "1"}
+	instance.Labels = map[string]string{"remove": "1"}
+	instance.Status.Reason = "old"
+	c := newTestClient(instance)
+	handler, err := NewReqHandler[*corev1.Pod, *TestReq]().
+		WithSteps(FuncStep{name: "step1", do: func(r *TestReq) Result {
+			delete(r.GetInstance().Annotations, "remove")
+			r.GetInstance().Labels = nil
+			r.GetInstance().Status.Reason = ""
+			r.GetInstance().Status.Message = "new"
+			return r.OK()
+		}}).
+		WithPersistenceStrategy(ServerSideApply[*corev1.Pod]{FieldManager: "okofw"}).
+		Build()
+	g.Expect(err).NotTo(HaveOccurred())
+
+	g.Expect(handler.handleReqWithReport(newTestReq(c), &Report{}).IsOK()).To(BeTrue())
+
+	// the removed fields are not owned by the field manager so the apply
+	// alone would keep them
+	g.Expect(c.Get(ctx, testInstanceName, instance)).To(Succeed())
+	g.Expect(instance.Annotations).To(Equal(map[string]string{"keep": "1"}))
+	g.Expect(instance.Labels).To(BeEmpty())
+	g.Expect(instance.Status.Reason).To(BeEmpty())
+	g.Expect(instance.Status.Message).To(Equal("new"))
+}
+
+func TestServerSideApplyLeavesOwnedRemovedFieldsToTheApply(t *testing.T) {
+	g := NewWithT(t)
+	snapshot := newTestInstance()
+	snapshot.Labels = map[string]string{"ours": "1", "theirs": "1"}
+	snapshot.ManagedFields = []metav1.ManagedFieldsEntry{
+		{
+			Manager:   "okofw",
+			Operation: metav1.ManagedFieldsOperationApply,
+			FieldsV1: &metav1.FieldsV1{
+				Raw: []byte(`{"f:metadata":{"f:labels":{"f:ours":{}}}}`),
+			},
+		},
+	}
+	instance := snapshot.DeepCopy()
+	instance.Labels = nil
+
+	strategy := ServerSideApply[*corev1.Pod]{FieldManager: "okofw"}
+	patch, err := strategy.removalPatch(instance, snapshot, "")
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(string(patch)).To(Equal(`{"metadata":{"labels":{"theirs":null}}}`))
+
+	patch, err = strategy.removalPatch(instance, snapshot, "status")
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(patch).To(BeNil())
+}
+
+func TestServerSideApplyCreateAndDelete(t *testing.T) {
+	g := NewWithT(t)
+	instance := newTestInstance()
+	instance.Finalizers = nil
+	c := newTestClient(instance)
+	cleanedUp := false
+	handler, err := NewReqHandler[*corev1.Pod, *TestReq]().
+		WithSteps(FuncStep{
+			name: "step1",
+			do: func(r *TestReq) Result {
+				r.GetInstance().Labels = map[string]string{"foo": "bar"}
+				r.GetInstance().Status.Reason = "ours"
+				return r.OK()
+			},
+			cleanup: func(r *TestReq) Result {
+				cleanedUp = true
+				r.GetInstance().Status.Reason = "deleting"
+				return r.OK()
+			},
+		}).
+		WithPersistenceStrategy(ServerSideApply[*corev1.Pod]{FieldManager: "okofw"}).
+		Build()
+	g.Expect(err).NotTo(HaveOccurred())
+
+	g.Expect(handler.handleReqWithReport(newTestReq(c), &Report{}).IsOK()).To(BeTrue())
+	g.Expect(c.Get(ctx, testInstanceName, instance)).To(Succeed())
+	g.Expect(instance.Finalizers).To(Equal([]string{"pod-finalizer"}))
+	g.Expect(instance.Labels).To(HaveKeyWithValue("foo", "bar"))
+	g.Expect(instance.Status.Reason).To(Equal("ours"))
+
+	g.Expect(c.Delete(ctx, instance)).To(Succeed())
+	g.Expect(handler.handleReqWithReport(newTestReq(c), &Report{}).IsOK()).To(BeTrue())
+	g.Expect(cleanedUp).To(BeTrue())
+	// the finalizer is not applied by the field manager but it is still
+	// removed so the instance is gone
+	err = c.Get(ctx, testInstanceName, instance)
+	g.Expect(k8s_errors.IsNotFound(err)).To(BeTrue())
+}
+
+// updateConcurrently returns a Do function that simulates that somebody else
+// updates the instance while we reconcile it
+func updateConcurrently(g *WithT, c client.Client, update func(pod *corev1.Pod)) func(r *TestReq) Result {
+	return func(r *TestReq) Result {
+		other := &corev1.Pod{}
+		g.Expect(c.Get(ctx, testInstanceName, other)).To(Succeed())
+		update(other)
+		g.Expect(c.Update(ctx, other)).To(Succeed())
+		return r.OK()
+	}
+}
+
+func TestMergePatchWithOptimisticLockReturnsConflict(t *testing.T) {
+	g := NewWithT(t)
+	c := newTestClient(newTestInstance())
+	handler, err := NewReqHandler[*corev1.Pod, *TestReq]().
+		WithSteps(
+			FuncStep{name: "step1", do: updateConcurrently(g, c, func(pod *corev1.Pod) {
+				pod.Labels = map[string]string{"foo": "other"}
+			})},
+			FuncStep{name: "step2", do: func(r *TestReq) Result {
+				r.GetInstance().Labels = map[string]string{"foo": "bar"}
+				return r.OK()
+			}},
+		).
+		WithPersistenceStrategy(MergePatch[*corev1.Pod]{OptimisticLock: true}).
+		Build()
+	g.Expect(err).NotTo(HaveOccurred())
+
+	req := newTestReq(c)
+	result := handler.handleReqWithReport(req, &Report{})
+	g.Expect(result.IsConflict()).To(BeTrue())
+	g.Expect(result.IsError()).To(BeFalse())
+	g.Expect(result.IsRequeue()).To(BeTrue())
+
+	instance := &corev1.Pod{}
+	g.Expect(c.Get(ctx, testInstanceName, instance)).To(Succeed())
+	g.Expect(instance.Labels).To(HaveKeyWithValue("foo", "other"))
+}
+
+func TestStatusConflictRequeuedByDefault(t *testing.T) {
+	g := NewWithT(t)
+	c := newTestClient(newTestInstance())
+	handler, err := NewReqHandler[*corev1.Pod, *TestReq]().
+		WithSteps(
+			FuncStep{name: "step1", do: updateConcurrently(g, c, func(pod *corev1.Pod) {
+				pod.Status.Message = "other"
+			})},
+			FuncStep{name: "step2", do: func(r *TestReq) Result {
+				r.GetInstance().Status.Reason = "ours"
+				return r.OK()
+			}},
+		).
+		WithPersistenceStrategy(MergePatch[*corev1.Pod]{OptimisticLock: true}).
+		Build()
+	g.Expect(err).NotTo(HaveOccurred())
+
+	result := handler.handleReqWithReport(newTestReq(c), &Report{})
+	g.Expect(result.IsConflict()).To(BeTrue())
+
+	instance := &corev1.Pod{}
+	g.Expect(c.Get(ctx, testInstanceName, instance)).To(Succeed())
+	g.Expect(instance.Status.Message).To(Equal("other"))
+	g.Expect(instance.Status.Reason).To(BeEmpty())
+}
+
+func TestStatusConflictRetried(t *testing.T) {
+	g := NewWithT(t)
+	c := newTestClient(newTestInstance())
+	handler, err := NewReqHandler[*corev1.Pod, *TestReq]().
+		WithSteps(
+			FuncStep{name: "step1", do: updateConcurrently(g, c, func(pod *corev1.Pod) {
+				pod.Status.Message = "other"
+			})},
+			FuncStep{name: "step2", do: func(r *TestReq) Result {
+				r.GetInstance().Status.Reason = "ours"
+				return r.OK()
+			}},
+		).
+		WithPersistenceStrategy(MergePatch[*corev1.Pod]{OptimisticLock: true}).
+		WithConflictPolicy(RetryStatusOnConflict).
+		Build()
+	g.Expect(err).NotTo(HaveOccurred())
+
+	result := handler.handleReqWithReport(newTestReq(c), &Report{})
+	g.Expect(result.IsOK()).To(BeTrue())
+
+	instance := &corev1.Pod{}
+	g.Expect(c.Get(ctx, testInstanceName, instance)).To(Succeed())
+	g.Expect(instance.Status.Message).To(Equal("other"))
+	g.Expect(instance.Status.Reason).To(Equal("ours"))
+}
+
+func TestSaveSkipsUnchangedParts(t *testing.T) {
+	g := NewWithT(t)
+	c := newTestClient(newTestInstance())
+	var change func(pod *corev1.Pod)
+	handler, err := NewReqHandler[*corev1.Pod, *TestReq]().
+		WithSteps(FuncStep{name: "step1", do: func(r *TestReq) Result {
+			change(r.GetInstance())
+			return r.OK()
+		}}).
+		Build()
+	g.Expect(err).NotTo(HaveOccurred())
+
+	change = func(pod *corev1.Pod) {}
+	g.Expect(handler.handleReqWithReport(newTestReq(c), &Report{}).IsOK()).To(BeTrue())
+	g.Expect(handler.GetWriteStats()).To(Equal(WriteStats{Issued: 0, Skipped: 2}))
+
+	change = func(pod *corev1.Pod) { pod.Status.Reason = "foo" }
+	g.Expect(handler.handleReqWithReport(newTestReq(c), &Report{}).IsOK()).To(BeTrue())
+	g.Expect(handler.GetWriteStats()).To(Equal(WriteStats{Issued: 1, Skipped: 3}))
+
+	change = func(pod *corev1.Pod) { pod.Labels = map[string]string{"foo": "bar"} }
+	g.Expect(handler.handleReqWithReport(newTestReq(c), &Report{}).IsOK()).To(BeTrue())
+	g.Expect(handler.GetWriteStats()).To(Equal(WriteStats{Issued: 2, Skipped: 4}))
+
+	instance := &corev1.Pod{}
+	g.Expect(c.Get(ctx, testInstanceName, instance)).To(Succeed())
+	g.Expect(instance.Labels).To(HaveKeyWithValue("foo", "bar"))
+	g.Expect(instance.Status.Reason).To(Equal("foo"))
+}
+
+// conflictingStatusClient is a client that fails every status write with a
+// Conflict
+type conflictingStatusClient struct {
+	client.Client
+}
+
+func (c conflictingStatusClient) Status() client.SubResourceWriter {
+	return conflictingStatusWriter{c.Client.Status()}
+}
+
+type conflictingStatusWriter struct {
+	client.SubResourceWriter
+}
+
+func (w conflictingStatusWriter) Patch(
+	ctx context.Context, obj client.Object, patch client.Patch,
+	opts ...client.SubResourcePatchOption,
+) error {
+	return k8s_errors.NewConflict(
+		schema.GroupResource{Resource: "pods"}, obj.GetName(), errors.New("boom"))
+}
+
+func TestStatusConflictRetriesExhausted(t *testing.T) {
+	g := NewWithT(t)
+	c := conflictingStatusClient{newTestClient(newTestInstance())}
+	handler, err := NewReqHandler[*corev1.Pod, *TestReq]().
+		WithSteps(
+			FuncStep{name: "step1", do: func(r *TestReq) Result {
+				r.GetInstance().Status.Reason = "ours"
+				return r.OK()
+			}},
+		).
+		WithConflictPolicy(RetryStatusOnConflict).
+		Build()
+	g.Expect(err).NotTo(HaveOccurred())
+
+	_, report, err := handler.HandleWithReport(newTestReq(c))
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(report.Result.IsConflict()).To(BeTrue())
+	g.Expect(report.StatusSaved).To(BeFalse())
+
+	instance := &corev1.Pod{}
+	g.Expect(c.Get(ctx, testInstanceName, instance)).To(Succeed())
+	g.Expect(instance.Status.Reason).To(BeEmpty())
+}
@@ -0,0 +1,142 @@
+package reconcile
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/openstack-k8s-operators/lib-common/modules/common/condition"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+
+	. "github.com/onsi/gomega"
+)
+
+// PodWithConditions adds condition handling to Pod by storing the
+// conditions in the PodStatus conditions list.
+type PodWithConditions struct {
+	corev1.Pod
+}
+
+func (p *PodWithConditions) GetConditions() condition.Conditions {
+	if p.Status.Conditions == nil {
+		return nil
+	}
+	conditions := condition.Conditions{}
+	for _, c := range p.Status.Conditions {
+		conditions = append(conditions, condition.Condition{
+			Type:               condition.Type(c.Type),
+			Status:             c.Status,
+			Reason:             condition.Reason(c.Reason),
+			Severity:           condition.SeverityError,
+			Message:            c.Message,
+			LastTransitionTime: c.LastTransitionTime,
+		})
+	}
+	return conditions
+}
+
+func (p *PodWithConditions) SetConditions(conditions condition.Conditions) {
+	p.Status.Conditions = []corev1.PodCondition{}
+	for _, c := range conditions {
+		p.Status.Conditions = append(p.Status.Conditions, corev1.PodCondition{
+			Type:               corev1.PodConditionType(c.Type),
+			Status:             c.Status,
+			Reason:             string(c.Reason),
+			Message:            c.Message,
+			LastTransitionTime: c.LastTransitionTime,
+		})
+	}
+}
+
+func (p *PodWithConditions) DeepCopyObject() runtime.Object {
+	return &PodWithConditions{Pod: *p.Pod.DeepCopy()}
+}
+
+func TestPanicInDoIsRecovered(t *testing.T) {
+	g := NewWithT(t)
+	c := newTestClient(newTestInstance())
+	postRun := false
+	handler, err := NewReqHandler[*corev1.Pod, *TestReq]().
+		WithSteps(
+			FuncStep{
+				name: "step1",
+				do: func(r *TestReq) Result {
+					r.GetInstance().Labels = map[string]string{"foo": "bar"}
+					panic("boom")
+				},
+				post: func(r *TestReq) Result {
+					postRun = true
+					return r.OK()
+				},
+			},
+		).
+		Build()
+	g.Expect(err).NotTo(HaveOccurred())
+
+	result := handler.handleReqWithReport(newTestReq(c), &Report{})
+	g.Expect(result.IsError()).To(BeTrue())
+	var panicErr *PanicError
+	g.Expect(errors.As(result.Err(), &panicErr)).To(BeTrue())
+	g.Expect(panicErr.Step).To(Equal("step1"))
+	g.Expect(panicErr.Value).To(Equal("boom"))
+	g.Expect(postRun).To(BeTrue())
+
+	// the changes before the panic are saved
+	instance := &corev1.Pod{}
+	g.Expect(c.Get(ctx, testInstanceName, instance)).To(Succeed())
+	g.Expect(instance.Labels).To(HaveKeyWithValue("foo", "bar"))
+}
+
+func TestPanicInParallelStepIsRecovered(t *testing.T) {
+	g := NewWithT(t)
+	c := newTestClient(newTestInstance())
+	handler, err := NewReqHandler[*corev1.Pod, *TestReq]().
+		WithSteps(
+			FuncStep{name: "step1", do: func(r *TestReq) Result {
+				panic("boom")
+			}},
+			FuncStep{name: "step2"},
+		).
+		WithParallelExecution().
+		Build()
+	g.Expect(err).NotTo(HaveOccurred())
+
+	result := handler.handleReqWithReport(newTestReq(c), &Report{})
+	_, isPanic := asPanicError(result)
+	g.Expect(isPanic).To(BeTrue())
+}
+
+func TestPanicSetsReconcileSucceededCondition(t *testing.T) {
+	g := NewWithT(t)
+	instance := &PodWithConditions{}
+	snapshot := &PodWithConditions{}
+	instance.SetConditions(condition.Conditions{})
+	snapshot.SetConditions(condition.Conditions{})
+
+	markReconcileFailed(instance, snapshot, "step step1 panicked: boom")
+	conditions := instance.GetConditions()
+	cond := conditions.Get(ReconcileSucceededCondition)
+	g.Expect(cond).NotTo(BeNil())
+	g.Expect(cond.Status).To(Equal(corev1.ConditionFalse))
+	g.Expect(cond.Reason).To(Equal(condition.Reason(condition.ErrorReason)))
+	g.Expect(cond.Message).To(Equal("step step1 panicked: boom"))
+
+	// the same failure keeps the transition time of the snapshot
+	snapshot = instance.DeepCopyObject().(*PodWithConditions)
+	snapshot.Status.Conditions[0].LastTransitionTime.Time = cond.LastTransitionTime.Add(-1e9)
+	next := &PodWithConditions{}
+	next.SetConditions(condition.Conditions{})
+	markReconcileFailed(next, snapshot, "step step1 panicked: boom")
+	nextConditions := next.GetConditions()
+	g.Expect(nextConditions.Get(ReconcileSucceededCondition).LastTransitionTime).To(
+		Equal(snapshot.Status.Conditions[0].LastTransitionTime))
+
+	clearReconcileFailed(instance)
+	conditions = instance.GetConditions()
+	g.Expect(conditions.Has(ReconcileSucceededCondition)).To(BeFalse())
+
+	// instances without initialized conditions are left alone
+	empty := &PodWithConditions{}
+	markReconcileFailed(empty, snapshot, "boom")
+	g.Expect(empty.GetConditions()).To(BeNil())
+}
@@ -0,0 +1,64 @@
+package reconcile
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+)
+
+// StepReport describes a single execution of a phase of a step
+type StepReport struct {
+	Step     string
+	Phase    string
+	Duration time.Duration
+	Result   Result
+}
+
+// Report describes what happened during the handling of a single reconcile
+// request. It is returned by Handler.HandleWithReport().
+type Report struct {
+	// Steps lists the phases of the steps in the order they finished.
+	// Steps that are not run, e.g. due to a failed dependency, are not
+	// listed.
+	Steps []StepReport
+	// InstanceSaved is true if the metadata and spec of the instance is
+	// patched
+	InstanceSaved bool
+	// StatusSaved is true if the status of the instance is patched
+	StatusSaved bool
+	// Result is the overall result of the request
+	Result Result
+
+	// the steps of a stage can run in parallel
+	lock sync.Mutex
+}
+
+func (r *Report) addStep(step StepReport) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	r.Steps = append(r.Steps, step)
+}
+
+// GetStep returns the report of the given phase of the given step. If the
+// phase of the step ran more than once then the last one is returned.
+func (r *Report) GetStep(phase string, step string) (StepReport, bool) {
+	for i := len(r.Steps) - 1; i >= 0; i-- {
+		if r.Steps[i].Phase == phase && r.Steps[i].Step == step {
+			return r.Steps[i], true
+		}
+	}
+	return StepReport{}, false
+}
+
+// String returns a single line summary of the report for debug logging
+func (r *Report) String() string {
+	steps := []string{}
+	for _, step := range r.Steps {
+		steps = append(steps, fmt.Sprintf(
+			"%s %s: %s (%s)", step.Phase, step.Step, step.Result, step.Duration))
+	}
+	return fmt.Sprintf(
+		"steps: [%s], instance saved: %t, status saved: %t, result: %s",
+		strings.Join(steps, "; "), r.InstanceSaved, r.StatusSaved, r.Result)
+}
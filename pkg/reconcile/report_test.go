@@ -0,0 +1,69 @@
+package reconcile
+
+import (
+	"errors"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+
+	. "github.com/onsi/gomega"
+)
+
+func TestHandleWithReport(t *testing.T) {
+	g := NewWithT(t)
+	c := newTestClient(newTestInstance())
+	handler, err := NewReqHandler[*corev1.Pod, *TestReq]().
+		WithSteps(
+			FuncStep{name: "step1", do: func(r *TestReq) Result {
+				r.GetInstance().Status.Reason = "foo"
+				return r.OK()
+			}},
+			FuncStep{name: "step2", do: func(r *TestReq) Result {
+				return r.Error(errors.New("boom"), r.GetLog())
+			}},
+			FuncStep{name: "step3"},
+		).
+		Build()
+	g.Expect(err).NotTo(HaveOccurred())
+
+	_, report, err := handler.HandleWithReport(newTestReq(c))
+	g.Expect(err).To(MatchError("boom"))
+
+	steps := []string{}
+	for _, step := range report.Steps {
+		steps = append(steps, step.Phase+" "+step.Step)
+	}
+	g.Expect(steps).To(Equal([]string{
+		"Do step1", "Do step2", "Post step1", "Post step2", "Post step3"}))
+
+	step2, found := report.GetStep("Do", "step2")
+	g.Expect(found).To(BeTrue())
+	g.Expect(step2.Result.IsError()).To(BeTrue())
+	g.Expect(step2.Duration).To(BeNumerically(">", 0))
+	_, found = report.GetStep("Do", "step3")
+	g.Expect(found).To(BeFalse())
+
+	g.Expect(report.InstanceSaved).To(BeFalse())
+	g.Expect(report.StatusSaved).To(BeTrue())
+	g.Expect(report.Result.IsError()).To(BeTrue())
+	g.Expect(report.String()).To(ContainSubstring("Do step2: Failure: boom"))
+	g.Expect(report.String()).To(HaveSuffix(
+		"instance saved: false, status saved: true, result: Failure: boom"))
+}
+
+func TestReportOfMissingInstance(t *testing.T) {
+	g := NewWithT(t)
+	c := newTestClient()
+	handler, err := NewReqHandler[*corev1.Pod, *TestReq]().
+		WithSteps(FuncStep{name: "step1"}).
+		Build()
+	g.Expect(err).NotTo(HaveOccurred())
+
+	_, report, err := handler.HandleWithReport(newTestReq(c))
+	g.Expect(err).NotTo(HaveOccurred())
+
+	g.Expect(report.Steps).To(BeEmpty())
+	g.Expect(report.InstanceSaved).To(BeFalse())
+	g.Expect(report.StatusSaved).To(BeFalse())
+	g.Expect(report.Result.IsOK()).To(BeTrue())
+}
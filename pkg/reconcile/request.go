@@ -2,11 +2,15 @@ package reconcile
 
 import (
 	"context"
+	"strings"
+	"sync"
 	"time"
 
 	"github.com/go-logr/logr"
+	"k8s.io/client-go/tools/record"
 	ctrl "sigs.k8s.io/controller-runtime"
 	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/apiutil"
 )
 
 type ResultGenerator interface {
@@ -14,10 +18,29 @@ type ResultGenerator interface {
 	Error(error, logr.Logger) Result
 	Requeue(msg string) Result
 	RequeueAfter(msg string, after *time.Duration) Result
+	// RequeueWithBackoff returns a requeue request where the delay grows
+	// exponentially with each consecutive request of the same step with the
+	// same key for the same instance, up to a maximum. The delay is reset
+	// when the step succeeds. See ReqHandlerBuilder.WithBackoff().
+	RequeueWithBackoff(msg string, key string) Result
+	// Terminal returns an error that cannot be fixed by retrying. The
+	// request is not requeued and the steps are not run again until the
+	// generation of the instance changes.
+	Terminal(err error) Result
+	// Conflict returns a requeue request caused by a conflicting update of
+	// the instance. It is logged as a conflict instead of a failure.
+	Conflict(err error) Result
+	// Timeout returns a requeue request caused by a step exceeding its
+	// timeout.
+	Timeout(err error) Result
 }
 
 // Req holds a single reconcile request
 // T is the CRD type the reconcile request running on
+//
+// The Handler changes the context, the logger and the result of the Do phase
+// of the request via ReqWithSetters if the request implements it, e.g. by
+// embedding DefaultReq into the CRD specific request type.
 type Req[T client.Object] interface {
 	GetCtx() context.Context
 	GetLog() logr.Logger
@@ -27,11 +50,63 @@ type Req[T client.Object] interface {
 	SnapshotInstance()
 	GetInstanceSnapshot() T
 	GetDefaultRequeueTimeout() time.Duration
+	// GetFinalizer returns the finalizer the Handler adds to the instance
+	// to be able to clean up before the instance is deleted
 	GetFinalizer() string
+	// GetLegacyFinalizers returns the finalizer names used by earlier
+	// versions of the controller. The Handler replaces them with the one
+	// returned by GetFinalizer on existing instances and removes them when
+	// the instance is deleted.
+	GetLegacyFinalizers() []string
+	// GetInstanceLock returns the lock that needs to be held while the
+	// instance is accessed from Steps that can run in parallel with other
+	// Steps. See ReqHandlerBuilder.WithParallelExecution()
+	GetInstanceLock() sync.Locker
+	// GetDoResult returns the result of the Do phase of the steps in this
+	// reconciliation, or nil if the Do phase did not run, e.g. the instance
+	// is being deleted. It is intended to be used in the Post phase.
+	GetDoResult() Result
+	// GetEventRecorder returns the recorder the steps can use to emit
+	// Kubernetes events about the instance. It is never nil.
+	GetEventRecorder() record.EventRecorder
 
 	ResultGenerator
 }
 
+// ReqWithSetters is an optional interface of Req. The Handler uses it to
+// replace the context returned by GetCtx, e.g. to limit the execution time of
+// the steps, to replace the logger returned by GetLog, e.g. to add structured
+// keys to every log line of the request, and to store the result returned by
+// GetDoResult. DefaultReq implements it. If a Req does not implement it then
+// the steps always get the original context and logger of the request.
+type ReqWithSetters interface {
+	SetCtx(ctx context.Context)
+	SetLog(log logr.Logger)
+	SetDoResult(result Result)
+}
+
+// setCtx replaces the context of the request if it implements ReqWithSetters
+func setCtx(r interface{}, ctx context.Context) {
+	if setter, ok := r.(ReqWithSetters); ok {
+		setter.SetCtx(ctx)
+	}
+}
+
+// setLog replaces the logger of the request if it implements ReqWithSetters
+func setLog(r interface{}, log logr.Logger) {
+	if setter, ok := r.(ReqWithSetters); ok {
+		setter.SetLog(log)
+	}
+}
+
+// setDoResult stores the result of the Do phase if the request implements
+// ReqWithSetters
+func setDoResult(r interface{}, result Result) {
+	if setter, ok := r.(ReqWithSetters); ok {
+		setter.SetDoResult(result)
+	}
+}
+
 // DefaultReq provides the minimal implementation of a reconcile request. This
 // can be used to embed into a CRD specific request type
 type DefaultReq[T client.Object] struct {
@@ -42,6 +117,18 @@ type DefaultReq[T client.Object] struct {
 	Instance         T
 	InstanceSnapshot T
 	RequeueTimeout   time.Duration
+	// Finalizer overrides the default finalizer name derived from the group
+	// and kind of the instance, i.e. <group>/<kind>-finalizer
+	Finalizer string
+	// LegacyFinalizers are finalizer names used by earlier versions of the
+	// controller that needs to be migrated to the current Finalizer
+	LegacyFinalizers []string
+	// Recorder is used to emit Kubernetes events about the instance, e.g.
+	// from mgr.GetEventRecorderFor(). If nil then events are not emitted.
+	Recorder record.EventRecorder
+
+	instanceLock sync.Mutex
+	doResult     Result
 }
 
 // --- implement Req[T]
@@ -50,10 +137,33 @@ func (r *DefaultReq[T]) GetCtx() context.Context {
 	return r.Ctx
 }
 
+func (r *DefaultReq[T]) SetCtx(ctx context.Context) {
+	r.Ctx = ctx
+}
+
+func (r *DefaultReq[T]) GetDoResult() Result {
+	return r.doResult
+}
+
+func (r *DefaultReq[T]) SetDoResult(result Result) {
+	r.doResult = result
+}
+
+func (r *DefaultReq[T]) GetEventRecorder() record.EventRecorder {
+	if r.Recorder == nil {
+		return noopRecorder{}
+	}
+	return r.Recorder
+}
+
 func (r *DefaultReq[T]) GetLog() logr.Logger {
 	return r.Log
 }
 
+func (r *DefaultReq[T]) SetLog(log logr.Logger) {
+	r.Log = log
+}
+
 func (r *DefaultReq[T]) GetRequest() ctrl.Request {
 	return r.Request
 }
@@ -70,30 +180,60 @@ func (r *DefaultReq[T]) SnapshotInstance() {
 	r.InstanceSnapshot = r.Instance.DeepCopyObject().(T)
 }
 
-func (r DefaultReq[T]) GetInstanceSnapshot() T {
+func (r *DefaultReq[T]) GetInstanceSnapshot() T {
 	return r.InstanceSnapshot
 }
 
-func (r DefaultReq[T]) GetDefaultRequeueTimeout() time.Duration {
+func (r *DefaultReq[T]) GetDefaultRequeueTimeout() time.Duration {
 	return r.RequeueTimeout
 }
 
-func (r DefaultReq[T]) GetFinalizer() string {
-	return r.GetInstance().GetObjectKind().GroupVersionKind().Kind
+func (r *DefaultReq[T]) GetFinalizer() string {
+	if r.Finalizer != "" {
+		return r.Finalizer
+	}
+	gvk, err := apiutil.GVKForObject(r.GetInstance(), r.GetClient().Scheme())
+	if err != nil {
+		return ""
+	}
+	finalizer := strings.ToLower(gvk.Kind) + "-finalizer"
+	if gvk.Group == "" {
+		return finalizer
+	}
+	return gvk.Group + "/" + finalizer
+}
+
+// GetLegacyFinalizers returns the LegacyFinalizers and the plain kind of the
+// instance that was used as the finalizer name before.
+func (r *DefaultReq[T]) GetLegacyFinalizers() []string {
+	legacy := append([]string{}, r.LegacyFinalizers...)
+	gvk, err := apiutil.GVKForObject(r.GetInstance(), r.GetClient().Scheme())
+	if err == nil {
+		legacy = append(legacy, gvk.Kind)
+	}
+	return legacy
+}
+
+func (r *DefaultReq[T]) GetInstanceLock() sync.Locker {
+	return &r.instanceLock
 }
 
 // --- implementing ResultGenerator
 
-func (r DefaultReq[T]) OK() Result {
+func (r *DefaultReq[T]) OK() Result {
 	return DefaultResult{Result: ctrl.Result{}, err: nil}
 }
 
-func (r DefaultReq[T]) Error(err error, log logr.Logger) Result {
+func (r *DefaultReq[T]) Error(err error, log logr.Logger) Result {
 	log.Error(err, "")
 	return DefaultResult{Result: ctrl.Result{}, err: err}
 }
 
-func (r DefaultReq[T]) Requeue(msg string) Result {
+func (r *DefaultReq[T]) Terminal(err error) Result {
+	return DefaultResult{Result: ctrl.Result{}, err: err, terminal: true}
+}
+
+func (r *DefaultReq[T]) Requeue(msg string) Result {
 	return DefaultResult{
 		Result:     ctrl.Result{Requeue: true},
 		err:        nil,
@@ -101,7 +241,7 @@ func (r DefaultReq[T]) Requeue(msg string) Result {
 	}
 }
 
-func (r DefaultReq[T]) RequeueAfter(msg string, after *time.Duration) Result {
+func (r *DefaultReq[T]) RequeueAfter(msg string, after *time.Duration) Result {
 	a := r.GetDefaultRequeueTimeout()
 	if after != nil {
 		a = *after
@@ -112,3 +252,31 @@ func (r DefaultReq[T]) RequeueAfter(msg string, after *time.Duration) Result {
 		requeueMsg: msg,
 	}
 }
+
+func (r *DefaultReq[T]) RequeueWithBackoff(msg string, key string) Result {
+	// the Handler calculates the actual delay based on the earlier attempts
+	return DefaultResult{
+		Result:     ctrl.Result{Requeue: true},
+		err:        nil,
+		requeueMsg: msg,
+		backoffKey: key,
+	}
+}
+
+func (r *DefaultReq[T]) Conflict(err error) Result {
+	return DefaultResult{
+		Result:     ctrl.Result{Requeue: true},
+		err:        nil,
+		requeueMsg: err.Error(),
+		conflict:   true,
+	}
+}
+
+func (r *DefaultReq[T]) Timeout(err error) Result {
+	return DefaultResult{
+		Result:     ctrl.Result{Requeue: true},
+		err:        nil,
+		requeueMsg: err.Error(),
+		timeout:    true,
+	}
+}
@@ -1,7 +1,9 @@
 package reconcile
 
 import (
+	"errors"
 	"fmt"
+	"strings"
 
 	ctrl "sigs.k8s.io/controller-runtime"
 )
@@ -14,15 +16,42 @@ type Result interface {
 	IsError() bool
 	IsRequeue() bool
 	IsOK() bool
+	// IsConflict returns true if the result is a requeue request due to
+	// a Conflict while persisting the instance
+	IsConflict() bool
+	// IsTimeout returns true if the result is a requeue request due to a
+	// step exceeding its timeout
+	IsTimeout() bool
+	// GetBackoffKey returns the key of a requeue request with exponential
+	// backoff or an empty string for other results
+	GetBackoffKey() string
+	// IsTerminal returns true if the result is an error that cannot be
+	// fixed by retrying the reconciliation without changing the spec of the
+	// instance. A terminal error is not returned to the controller-runtime
+	// so the request is not requeued.
+	IsTerminal() bool
 }
 
 type DefaultResult struct {
 	ctrl.Result
 	err        error
 	requeueMsg string
+	conflict   bool
+	timeout    bool
+	backoffKey string
+	terminal   bool
 }
 
 func (r DefaultResult) String() string {
+	if r.IsConflict() {
+		return fmt.Sprintf("Conflict: %s", r.requeueMsg)
+	}
+	if r.IsTimeout() {
+		return fmt.Sprintf("Timeout: %s", r.requeueMsg)
+	}
+	if r.IsTerminal() {
+		return fmt.Sprintf("Terminal failure: %v", r.err)
+	}
 	if r.IsError() {
 		return fmt.Sprintf("Failure: %v", r.err)
 	}
@@ -33,6 +62,10 @@ func (r DefaultResult) String() string {
 }
 
 func (r DefaultResult) Unwrap() (ctrl.Result, error) {
+	if r.IsTerminal() {
+		// retrying does not help so do not requeue
+		return ctrl.Result{}, nil
+	}
 	return r.Result, r.err
 }
 
@@ -51,3 +84,112 @@ func (r DefaultResult) Err() error {
 func (r DefaultResult) IsOK() bool {
 	return !r.IsError() && !r.IsRequeue()
 }
+
+func (r DefaultResult) IsConflict() bool {
+	return r.conflict
+}
+
+func (r DefaultResult) IsTimeout() bool {
+	return r.timeout
+}
+
+func (r DefaultResult) IsTerminal() bool {
+	return r.terminal
+}
+
+func (r DefaultResult) GetBackoffKey() string {
+	return r.backoffKey
+}
+
+// MergeResults combines multiple results into one. If any of the results is
+// an error then the merged result is an error joining all the errors. The
+// merged error is terminal only if all the errors are terminal.
+// Otherwise if any of the results requests a requeue then the merged result
+// requests a requeue with the shortest RequeueAfter and with all the
+// requeue messages. A merged requeue is a conflict or timeout if any of the
+// requeues is. If every result is OK then the merged result is OK too.
+func MergeResults(results ...Result) Result {
+	errs := []error{}
+	var requeue *ctrl.Result
+	msgs := []string{}
+	conflict := false
+	timeout := false
+	terminal := true
+	for _, result := range results {
+		switch {
+		case result == nil || result.IsOK():
+			continue
+		case result.IsError():
+			errs = append(errs, result.Err())
+			terminal = terminal && result.IsTerminal()
+		default:
+			res, _ := result.Unwrap()
+			if requeue == nil || shorterRequeue(res, *requeue) {
+				requeue = &res
+			}
+			msgs = append(msgs, requeueMsg(result))
+			conflict = conflict || result.IsConflict()
+			timeout = timeout || result.IsTimeout()
+		}
+	}
+
+	switch {
+	case len(errs) == 1:
+		return DefaultResult{err: errs[0], terminal: terminal}
+	case len(errs) > 1:
+		return DefaultResult{err: &joinedError{errs: errs}, terminal: terminal}
+	case requeue != nil:
+		return DefaultResult{
+			Result:     *requeue,
+			requeueMsg: strings.Join(msgs, "; "),
+			conflict:   conflict,
+			timeout:    timeout,
+		}
+	default:
+		return DefaultResult{}
+	}
+}
+
+// shorterRequeue returns true if a requests an earlier requeue than b
+func shorterRequeue(a ctrl.Result, b ctrl.Result) bool {
+	return a.RequeueAfter < b.RequeueAfter
+}
+
+func requeueMsg(result Result) string {
+	if r, ok := result.(DefaultResult); ok {
+		return r.requeueMsg
+	}
+	return result.String()
+}
+
+// joinedError is an error of multiple errors that supports errors.Is and
+// errors.As on each of them
+type joinedError struct {
+	errs []error
+}
+
+func (e *joinedError) Error() string {
+	msgs := []string{}
+	for _, err := range e.errs {
+		msgs = append(msgs, err.Error())
+	}
+	return strings.Join(msgs, "; ")
+}
+
+func (e *joinedError) Is(target error) bool {
+	for _, err := range e.errs {
+		if errors.Is(err, target) {
+			return true
+		}
+	}
+	return false
+}
+
+func (e *joinedError) As(target interface{}) bool {
+	for _, err := range e.errs {
+		if errors.As(err, target) {
+			return true
+		}
+	}
+	return false
+}
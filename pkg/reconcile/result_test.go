@@ -0,0 +1,102 @@
+package reconcile
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+
+	. "github.com/onsi/gomega"
+)
+
+func TestMergeResultsAllOK(t *testing.T) {
+	g := NewWithT(t)
+	r := &TestReq{}
+
+	g.Expect(MergeResults().IsOK()).To(BeTrue())
+	g.Expect(MergeResults(r.OK(), r.OK()).IsOK()).To(BeTrue())
+}
+
+func TestMergeResultsShortestRequeueWins(t *testing.T) {
+	g := NewWithT(t)
+	r := &TestReq{}
+	short := 1 * time.Second
+	long := 10 * time.Second
+
+	merged := MergeResults(
+		r.RequeueAfter("long", &long), r.OK(), r.RequeueAfter("short", &short))
+
+	g.Expect(merged.IsRequeue()).To(BeTrue())
+	res, err := merged.Unwrap()
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(res.RequeueAfter).To(Equal(short))
+	g.Expect(merged.String()).To(ContainSubstring("long; short"))
+}
+
+func TestMergeResultsJoinsErrors(t *testing.T) {
+	g := NewWithT(t)
+	r := &TestReq{}
+	err1 := errors.New("err1")
+	err2 := &PanicError{Step: "step2", Value: "boom"}
+	short := 1 * time.Second
+
+	merged := MergeResults(
+		r.Error(err1, r.GetLog()), r.RequeueAfter("short", &short),
+		r.Error(err2, r.GetLog()))
+
+	g.Expect(merged.IsError()).To(BeTrue())
+	res, err := merged.Unwrap()
+	g.Expect(res.Requeue).To(BeFalse())
+	g.Expect(err).To(MatchError("err1; step step2 panicked: boom"))
+	g.Expect(errors.Is(err, err1)).To(BeTrue())
+	var panicErr *PanicError
+	g.Expect(errors.As(err, &panicErr)).To(BeTrue())
+	g.Expect(panicErr).To(Equal(err2))
+}
+
+func TestMergeResultsKeepsConflict(t *testing.T) {
+	g := NewWithT(t)
+	r := &TestReq{}
+
+	merged := MergeResults(r.Requeue("not ready"), r.Conflict(errors.New("conflict")))
+
+	g.Expect(merged.IsConflict()).To(BeTrue())
+	g.Expect(merged.IsRequeue()).To(BeTrue())
+}
+
+func TestPostResultMergedWithDoResult(t *testing.T) {
+	g := NewWithT(t)
+	short := 1 * time.Second
+	long := 10 * time.Second
+	post2Run := false
+	handler, err := NewReqHandler[*corev1.Pod, *TestReq]().
+		WithSteps(
+			FuncStep{
+				name: "step1",
+				do: func(r *TestReq) Result {
+					return r.RequeueAfter("do", &long)
+				},
+				post: func(r *TestReq) Result {
+					return r.RequeueAfter("post1", &short)
+				},
+			},
+			FuncStep{
+				name: "step2",
+				post: func(r *TestReq) Result {
+					post2Run = true
+					return r.OK()
+				},
+			},
+		).
+		Build()
+	g.Expect(err).NotTo(HaveOccurred())
+
+	result := handler.handleReqWithReport(newTestReq(newTestClient(newTestInstance())), &Report{})
+
+	g.Expect(post2Run).To(BeTrue())
+	res, err := result.Unwrap()
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(res.RequeueAfter).To(Equal(short))
+	g.Expect(result.String()).To(ContainSubstring("do; post1"))
+}
@@ -1,6 +1,8 @@
 package reconcile
 
 import (
+	"time"
+
 	"github.com/go-logr/logr"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 )
@@ -11,9 +13,10 @@ type Step[T client.Object, R Req[T]] interface {
 	// GetName returns the name of the step
 	GetName() string
 	// Setup allow late initialization of the step based on all the
-	// other steps added to the RequestHandler. It runs before any Step
-	// execution.
-	Setup(steps []Step[T, R], log logr.Logger)
+	// other steps added to the Handler. It runs once when the Handler is
+	// built. If it returns an error then the Handler is not built. Use
+	// ValidationError to report an invalid step configuration.
+	Setup(steps []Step[T, R], log logr.Logger) error
 	// Do actual reconciliation step on the request.
 	// The passed in logger is already set up to have the step name as a
 	// context.
@@ -21,30 +24,139 @@ type Step[T client.Object, R Req[T]] interface {
 	// function run and the engine moves to execute the Post calls
 	// of each Step and then saves the CR.
 	Do(r R, log logr.Logger) Result
-	// Cleanup resources and finalizers during the deletion of the CR.
-	// If Cleanup returns an error or requests a requeue then no other Step's
-	// Cleanup run and the engine moves to execute the Post calls
-	// of each Step and then saves the CR.
+}
+
+// StepWithCleanup is an optional interface a Step can implement to clean up
+// resources and finalizers during the deletion of the CR.
+// If Cleanup returns an error or requests a requeue then no other Step's
+// Cleanup run and the engine moves to execute the Post calls
+// of each Step and then saves the CR.
+type StepWithCleanup[T client.Object, R Req[T]] interface {
 	Cleanup(r R, log logr.Logger) Result
-	// Post is called after each step's Do or Cleanup to do late actions
-	// just before persisting the CR and returning a result to the
-	// controller-runtime.
-	// If Post returns an error or requests a requeue then no other Step's
-	// Post runs and the engine just saves the CR.
+}
+
+// StepWithPost is an optional interface a Step can implement to do late
+// actions after each step's Do or Cleanup just before persisting the CR and
+// returning a result to the controller-runtime.
+// If Post returns an error then no other Step's Post runs and the engine
+// just saves the CR. If Post requests a requeue then the rest of the
+// Post calls still run and their requeue requests are merged via
+// MergeResults.
+type StepWithPost[T client.Object, R Req[T]] interface {
 	Post(r R, log logr.Logger) Result
 }
 
-// BaseStep is an empty struct that gives default implementation for some of
-// the not mandatory Step functions like Setup.
-type BaseStep[T client.Object, R Req[T]] struct {
+// StageValidator is an optional interface a Step can implement to validate
+// how the steps are grouped into stages when parallel execution is enabled
+// via ReqHandlerBuilder.WithParallelExecution(). The steps in the same stage
+// run their Do concurrently. If ValidateStages returns an error then the
+// Handler is not built. Use ValidationError to report the problem.
+type StageValidator[T client.Object, R Req[T]] interface {
+	ValidateStages(stages [][]Step[T, R]) error
 }
 
-func (s BaseStep[T, R]) Setup(steps []Step[T, R], log logr.Logger) {}
+// StepWithTimeout is an optional interface a Step can implement to limit the
+// execution time of its Do, Cleanup and Post functions. Each call gets a
+// fresh budget. The context returned by Req.GetCtx() is cancelled when the
+// timeout is exceeded, so the Step needs to pass it to every potentially
+// blocking call. If the Step fails after its timeout is exceeded then the
+// Handler returns a Result with IsTimeout() true and requeues the request.
+// If steps are run in parallel then the whole stage gets the longest timeout
+// of its steps, and no timeout if any step in the stage has none.
+type StepWithTimeout interface {
+	GetTimeout() time.Duration
+}
+
+// StepWithContinueOnError is an optional interface a Step can implement to
+// request that the Do of the rest of the steps still runs if its Do fails,
+// except the steps depending on it. See
+// ReqHandlerBuilder.WithContinueOnError() to request it for every step.
+type StepWithContinueOnError interface {
+	ContinueOnError() bool
+}
+
+// StepWithInit is an optional interface a Step can implement to run
+// actions when the CR is first seen, before the finalizer is added to it.
+// The Init of the steps runs in the order of Do. If an Init fails then the
+// finalizer is not added and Init is retried in the next reconciliation, so
+// it needs to be idempotent.
+type StepWithInit[T client.Object, R Req[T]] interface {
+	Init(r R, log logr.Logger) Result
+}
+
+// StepWithPreDelete is an optional interface a Step can implement to run
+// actions during the deletion of the CR before any Cleanup runs, e.g. to
+// scale down a service. The PreDelete of the steps runs in the order of Do
+// in every reconciliation until every Cleanup succeeded, so it needs to be
+// idempotent.
+type StepWithPreDelete[T client.Object, R Req[T]] interface {
+	PreDelete(r R, log logr.Logger) Result
+}
 
-func (s BaseStep[T, R]) Cleanup(r R, log logr.Logger) Result {
-	return r.OK()
+// StepWithFinalize is an optional interface a Step can implement to run
+// actions after the finalizer is removed from the CR and that is persisted.
+// The Finalize of the steps runs in the order of Cleanup. The CR might be
+// already deleted at this point so changes of the CR are not persisted.
+// Finalize is best-effort: it is never retried, a failure is only logged and
+// reported, and it does not stop the Finalize of the other steps.
+type StepWithFinalize[T client.Object, R Req[T]] interface {
+	Finalize(r R, log logr.Logger) Result
+}
+
+// implementsPhase returns true if the step implements the interface I of an
+// optional phase. A step wrapping another step, e.g. via When(), only
+// implements it if the wrapped step does, so the Handler does not call
+// phases that would do nothing.
+func implementsPhase[I any](step any) bool {
+	if _, ok := step.(I); !ok {
+		return false
+	}
+	if wrapper, ok := step.(wrappedStep); ok {
+		return implementsPhase[I](wrapper.unwrapStep())
+	}
+	return true
+}
+
+func initF[T client.Object, R Req[T]](step Step[T, R]) (func(r R, log logr.Logger) Result, bool) {
+	if !implementsPhase[StepWithInit[T, R]](step) {
+		return nil, false
+	}
+	return step.(StepWithInit[T, R]).Init, true
+}
+
+func preDeleteF[T client.Object, R Req[T]](step Step[T, R]) (func(r R, log logr.Logger) Result, bool) {
+	if !implementsPhase[StepWithPreDelete[T, R]](step) {
+		return nil, false
+	}
+	return step.(StepWithPreDelete[T, R]).PreDelete, true
+}
+
+func cleanupF[T client.Object, R Req[T]](step Step[T, R]) (func(r R, log logr.Logger) Result, bool) {
+	if !implementsPhase[StepWithCleanup[T, R]](step) {
+		return nil, false
+	}
+	return step.(StepWithCleanup[T, R]).Cleanup, true
+}
+
+func postF[T client.Object, R Req[T]](step Step[T, R]) (func(r R, log logr.Logger) Result, bool) {
+	if !implementsPhase[StepWithPost[T, R]](step) {
+		return nil, false
+	}
+	return step.(StepWithPost[T, R]).Post, true
+}
+
+func finalizeF[T client.Object, R Req[T]](step Step[T, R]) (func(r R, log logr.Logger) Result, bool) {
+	if !implementsPhase[StepWithFinalize[T, R]](step) {
+		return nil, false
+	}
+	return step.(StepWithFinalize[T, R]).Finalize, true
+}
+
+// BaseStep is an empty struct that gives default implementation for some of
+// the not mandatory Step functions like Setup.
+type BaseStep[T client.Object, R Req[T]] struct {
 }
 
-func (s BaseStep[T, R]) Post(r R, log logr.Logger) Result {
-	return r.OK()
+func (s BaseStep[T, R]) Setup(steps []Step[T, R], log logr.Logger) error {
+	return nil
 }
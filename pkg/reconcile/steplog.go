@@ -0,0 +1,110 @@
+package reconcile
+
+import (
+	"github.com/go-logr/logr"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/uuid"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller"
+)
+
+const (
+	// DefaultStepLogLevel is the verbosity level of the log of a step
+	// result that is the same as in the previous reconciliation
+	DefaultStepLogLevel = 1
+)
+
+// The structured keys of every log line of a request. The Handler only adds
+// the ones missing from the logger of the request, see addLogKeys.
+const (
+	ControllerLogKey  = "controller"
+	InstanceLogKey    = "instance"
+	ReconcileIDLogKey = "reconcileID"
+	GenerationLogKey  = "generation"
+)
+
+// stepLogID identifies the result of a phase of a step
+type stepLogID struct {
+	phase string
+	step  string
+}
+
+// stepLogs tracks the last result of each phase of each step per instance so
+// a result is only logged at the normal level if it changed since the
+// previous reconciliation.
+type stepLogs struct {
+	*instanceStore[stepLogID, string]
+}
+
+func newStepLogs() *stepLogs {
+	return &stepLogs{newInstanceStore[stepLogID, string]()}
+}
+
+// changed records the result of the step and returns true if it differs
+// from the previously recorded result
+func (l *stepLogs) changed(
+	instance types.NamespacedName, phase string, step string, result Result,
+) bool {
+	msg := result.String()
+	changed := false
+	id := stepLogID{phase: phase, step: step}
+	l.update(instance, id, func(previous string, found bool) string {
+		changed = !found || previous != msg
+		return msg
+	})
+	return changed
+}
+
+// logStepResult logs the result of a phase of a step. Errors are always
+// logged, other results only at the normal level if they changed since the
+// previous reconciliation of the instance, otherwise at the stepLogLevel
+// verbosity.
+func (h *Handler[T, R]) logStepResult(
+	r R, phase string, step string, result Result, log logr.Logger,
+) {
+	changed := h.stepLogs.changed(r.GetRequest().NamespacedName, phase, step, result)
+	switch {
+	case result.IsError():
+		log.Error(result.Err(), result.String())
+	case changed:
+		log.Info(result.String())
+	default:
+		log.V(h.stepLogLevel).Info(result.String())
+	}
+}
+
+// addLogKeys adds the controller, the instance and a new reconcileID to the
+// logger of the request so every line logged during the request can be
+// correlated. If the request is handled by a controller-runtime controller
+// then the logger from log.FromContext() already has the reconcileID of the
+// controller and the controller named after the lower case kind of the
+// instance by default. So then the reconcileID is not added and the
+// controller only if the Handler has a different name, see
+// ReqHandlerBuilder.WithControllerName(). The instance is always added.
+func (h *Handler[T, R]) addLogKeys(r R) {
+	keysAndValues := h.logKeys(r, controller.ReconcileIDFromContext(r.GetCtx()))
+	setLog(r, r.GetLog().WithValues(keysAndValues...))
+}
+
+// logKeys returns the keys and values addLogKeys adds to the logger of the
+// request given the reconcileID of the controller-runtime controller, if any
+func (h *Handler[T, R]) logKeys(r R, reconcileID types.UID) []interface{} {
+	keysAndValues := []interface{}{
+		InstanceLogKey, r.GetRequest().NamespacedName.String(),
+	}
+	if reconcileID == "" {
+		return append(keysAndValues,
+			ControllerLogKey, h.controllerName,
+			ReconcileIDLogKey, string(uuid.NewUUID()))
+	}
+	if h.controllerName != defaultControllerName[T]() {
+		keysAndValues = append(keysAndValues, ControllerLogKey, h.controllerName)
+	}
+	return keysAndValues
+}
+
+// addGenerationLogKey adds the generation of the instance to the logger of
+// the request once the instance is read
+func addGenerationLogKey[T client.Object, R Req[T]](r R) {
+	setLog(r, r.GetLog().WithValues(GenerationLogKey, r.GetInstance().GetGeneration()))
+}
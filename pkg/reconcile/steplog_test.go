@@ -0,0 +1,196 @@
+package reconcile
+
+import (
+	"errors"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/go-logr/logr"
+	"github.com/go-logr/logr/funcr"
+	corev1 "k8s.io/api/core/v1"
+
+	. "github.com/onsi/gomega"
+)
+
+// newCapturingLogger returns a logger that appends every line logged up to
+// the given verbosity to lines
+func newCapturingLogger(lines *[]string, verbosity int) logr.Logger {
+	return funcr.New(func(prefix, args string) {
+		*lines = append(*lines, prefix+" "+args)
+	}, funcr.Options{Verbosity: verbosity})
+}
+
+// linesOf returns the lines logged by the given logger name
+func linesOf(lines []string, name string) []string {
+	found := []string{}
+	for _, line := range lines {
+		if strings.HasPrefix(line, name+" ") {
+			found = append(found, line)
+		}
+	}
+	return found
+}
+
+func TestStepsWithoutOptionalPhasesAreNotRun(t *testing.T) {
+	g := NewWithT(t)
+	c := newTestClient(newTestInstance())
+	handler, err := NewReqHandler[*corev1.Pod, *TestReq]().
+		WithSteps(
+			NamedStep{name: "step1"},
+			FuncStep{name: "step2"},
+			When(func(r *TestReq) bool { return true }, TestStep(NamedStep{name: "step3"})),
+		).
+		Build()
+	g.Expect(err).NotTo(HaveOccurred())
+
+	_, report, err := handler.HandleWithReport(newTestReq(c))
+	g.Expect(err).NotTo(HaveOccurred())
+
+	steps := []string{}
+	for _, step := range report.Steps {
+		steps = append(steps, step.Phase+" "+step.Step)
+	}
+	g.Expect(steps).To(Equal([]string{"Do step1", "Do step2", "Do step3", "Post step2"}))
+}
+
+func TestStepResultIsLoggedOnlyOnChange(t *testing.T) {
+	g := NewWithT(t)
+	c := newTestClient(newTestInstance())
+	requeue := false
+	handler, err := NewReqHandler[*corev1.Pod, *TestReq]().
+		WithSteps(FuncStep{name: "step1", do: func(r *TestReq) Result {
+			if requeue {
+				return r.Requeue("waiting")
+			}
+			return r.OK()
+		}}).
+		Build()
+	g.Expect(err).NotTo(HaveOccurred())
+
+	handle := func() []string {
+		lines := []string{}
+		r := newTestReq(c)
+		r.Log = newCapturingLogger(&lines, 0)
+		_, _ = handler.Handle(r)
+		return linesOf(lines, "step1")
+	}
+
+	g.Expect(handle()).To(ConsistOf(ContainSubstring(`"msg"="Succeeded"`)))
+	// the same result is only logged at a higher verbosity
+	g.Expect(handle()).To(BeEmpty())
+
+	requeue = true
+	g.Expect(handle()).To(ConsistOf(ContainSubstring(`"msg"="Requeue`)))
+	g.Expect(handle()).To(BeEmpty())
+
+	requeue = false
+	g.Expect(handle()).To(ConsistOf(ContainSubstring(`"msg"="Succeeded"`)))
+}
+
+func TestStepLogLevel(t *testing.T) {
+	g := NewWithT(t)
+	c := newTestClient(newTestInstance())
+	handler, err := NewReqHandler[*corev1.Pod, *TestReq]().
+		WithSteps(FuncStep{name: "step1"}).
+		WithStepLogLevel(2).
+		Build()
+	g.Expect(err).NotTo(HaveOccurred())
+
+	for i, verbosity := range []int{0, 1, 2} {
+		lines := []string{}
+		r := newTestReq(c)
+		r.Log = newCapturingLogger(&lines, verbosity)
+		_, err := handler.Handle(r)
+		g.Expect(err).NotTo(HaveOccurred())
+		if i == 0 || verbosity >= 2 {
+			g.Expect(linesOf(lines, "step1")).To(HaveLen(1))
+		} else {
+			g.Expect(linesOf(lines, "step1")).To(BeEmpty())
+		}
+	}
+}
+
+func TestErrorsAreAlwaysLogged(t *testing.T) {
+	g := NewWithT(t)
+	c := newTestClient(newTestInstance())
+	handler, err := NewReqHandler[*corev1.Pod, *TestReq]().
+		WithSteps(FuncStep{name: "step1", do: func(r *TestReq) Result {
+			return r.Error(errors.New("boom"), r.GetLog())
+		}}).
+		Build()
+	g.Expect(err).NotTo(HaveOccurred())
+
+	for i := 0; i < 2; i++ {
+		lines := []string{}
+		r := newTestReq(c)
+		r.Log = newCapturingLogger(&lines, 0)
+		_, _ = handler.Handle(r)
+		g.Expect(linesOf(lines, "step1")).To(
+			ContainElement(ContainSubstring(`"error"="boom"`)))
+	}
+}
+
+var reconcileIDRegexp = regexp.MustCompile(`"reconcileID"="([0-9a-f-]{36})"`)
+
+// The test context has no reconcileID of a controller-runtime controller so
+// the Handler adds the keys itself
+func TestLogLinesHaveStructuredKeysOutsideController(t *testing.T) {
+	g := NewWithT(t)
+	instance := newTestInstance()
+	instance.Generation = 3
+	c := newTestClient(instance)
+	handler, err := NewReqHandler[*corev1.Pod, *TestReq]().
+		WithSteps(FuncStep{name: "step1"}).
+		WithControllerName("test-controller").
+		Build()
+	g.Expect(err).NotTo(HaveOccurred())
+
+	reconcileIDs := map[string]bool{}
+	for i := 0; i < 2; i++ {
+		lines := []string{}
+		r := newTestReq(c)
+		r.Log = newCapturingLogger(&lines, 1)
+		_, err := handler.Handle(r)
+		g.Expect(err).NotTo(HaveOccurred())
+
+		g.Expect(lines).NotTo(BeEmpty())
+		for _, line := range lines {
+			g.Expect(line).To(ContainSubstring(`"controller"="test-controller"`))
+			g.Expect(line).To(ContainSubstring(`"instance"="test-ns/test"`))
+			match := reconcileIDRegexp.FindStringSubmatch(line)
+			g.Expect(match).To(HaveLen(2))
+			reconcileIDs[match[1]] = true
+		}
+		g.Expect(linesOf(lines, "step1")).To(
+			ConsistOf(ContainSubstring(`"generation"=3`)))
+	}
+	// every request has its own reconcileID
+	g.Expect(reconcileIDs).To(HaveLen(2))
+}
+
+func TestLogKeysInController(t *testing.T) {
+	g := NewWithT(t)
+	r := newTestReq(newTestClient())
+	handler, err := NewReqHandler[*corev1.Pod, *TestReq]().
+		WithSteps(FuncStep{name: "step1"}).
+		Build()
+	g.Expect(err).NotTo(HaveOccurred())
+
+	// the logger of the controller already has the controller named after
+	// the kind and the reconcileID
+	g.Expect(handler.logKeys(r, "id")).To(Equal([]interface{}{
+		InstanceLogKey, "test-ns/test",
+	}))
+
+	handler, err = NewReqHandler[*corev1.Pod, *TestReq]().
+		WithSteps(FuncStep{name: "step1"}).
+		WithControllerName("test-controller").
+		Build()
+	g.Expect(err).NotTo(HaveOccurred())
+
+	g.Expect(handler.logKeys(r, "id")).To(Equal([]interface{}{
+		InstanceLogKey, "test-ns/test",
+		ControllerLogKey, "test-controller",
+	}))
+}
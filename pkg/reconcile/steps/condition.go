@@ -37,13 +37,24 @@ func (s Conditions[T, R]) GetName() string {
 	return "Conditions"
 }
 
+// ConditionsStep is implemented by the Conditions step regardless of its type
+// parameters. So a step that does not require T to implement
+// InstanceWithConditions can still depend on the Conditions step via
+// reconcile.OnStepType[ConditionsStep]().
+type ConditionsStep interface {
+	isConditionsStep()
+}
+
+func (s *Conditions[T, R]) isConditionsStep() {}
+
 func (s *Conditions[T, R]) Setup(
 	steps []reconcile.Step[T, R],
 	log logr.Logger,
-) {
+) error {
 	// collect all the conditions other steps are managing but ignore
 	// duplicates
 	conditions := map[condition.Type]condition.Condition{}
+	owners := map[condition.Type]string{}
 	// look for ourselves in the step list. If there are other
 	// ConditionManagers in the list before us that is a programmer error.
 	foundOurselves := false
@@ -51,17 +62,36 @@ func (s *Conditions[T, R]) Setup(
 		if step == s {
 			foundOurselves = true
 		}
+		// a step not managing any condition, e.g. a step wrapped by
+		// reconcile.When() without conditions, can be anywhere
 		condMgr, ok := step.(ConditionManager)
-		if ok {
+		if ok && len(condMgr.GetManagedConditions()) > 0 {
 			if !foundOurselves {
-				panic(
-					fmt.Sprintf(
+				return &reconcile.ValidationError{
+					Reason: reconcile.StepOrderViolation,
+					Step:   step.GetName(),
+					Msg: fmt.Sprintf(
 						"Step order error. Cannot add step %s which is a "+
 							"ConditionManager before step steps.Conditions",
-						step.GetName()))
+						step.GetName()),
+				}
 			}
 			for _, cond := range condMgr.GetManagedConditions() {
+				// the same condition can be managed by multiple steps but
+				// they need to agree on the initial state of it
+				if existing, found := conditions[cond.Type]; found &&
+					!hasSameInitialState(existing, cond) {
+					return &reconcile.ValidationError{
+						Reason: reconcile.ConditionOwnershipConflict,
+						Step:   step.GetName(),
+						Msg: fmt.Sprintf(
+							"Step %s initializes condition %s differently "+
+								"than step %s",
+							step.GetName(), cond.Type, owners[cond.Type]),
+					}
+				}
 				conditions[cond.Type] = cond
+				owners[cond.Type] = step.GetName()
 			}
 		}
 	}
@@ -69,9 +99,67 @@ func (s *Conditions[T, R]) Setup(
 	delete(conditions, condition.ReadyCondition)
 
 	s.conditions = maps.Values(conditions)
+	return nil
+}
+
+func hasSameInitialState(c1 condition.Condition, c2 condition.Condition) bool {
+	return c1.Status == c2.Status &&
+		c1.Reason == c2.Reason &&
+		c1.Severity == c2.Severity &&
+		c1.Message == c2.Message
+}
+
+// ValidateStages ensures that when the Do of the steps run in parallel then
+// every ConditionManager runs after the conditions are initialized and no
+// two ConditionManagers in the same stage manage the same condition, so the
+// condition updates are deterministic.
+func (s *Conditions[T, R]) ValidateStages(stages [][]reconcile.Step[T, R]) error {
+	foundOurselves := false
+	for _, stage := range stages {
+		owners := map[condition.Type]string{}
+		for _, step := range stage {
+			condMgr, ok := step.(ConditionManager)
+			if !ok || len(condMgr.GetManagedConditions()) == 0 {
+				continue
+			}
+			if !foundOurselves {
+				return &reconcile.ValidationError{
+					Reason: reconcile.StepOrderViolation,
+					Step:   step.GetName(),
+					Msg: fmt.Sprintf(
+						"Step order error. Step %s is a ConditionManager so "+
+							"it needs to depend on step steps.Conditions "+
+							"when steps run in parallel",
+						step.GetName()),
+				}
+			}
+			for _, cond := range condMgr.GetManagedConditions() {
+				if owner, found := owners[cond.Type]; found {
+					return &reconcile.ValidationError{
+						Reason: reconcile.ConditionOwnershipConflict,
+						Step:   step.GetName(),
+						Msg: fmt.Sprintf(
+							"Step %s and step %s both manage condition %s "+
+								"and they can run in parallel",
+							owner, step.GetName(), cond.Type),
+					}
+				}
+				owners[cond.Type] = step.GetName()
+			}
+		}
+		for _, step := range stage {
+			if step == s {
+				foundOurselves = true
+			}
+		}
+	}
+	return nil
 }
 
 func (s Conditions[T, R]) Do(r R, log logr.Logger) reconcile.Result {
+	r.GetInstanceLock().Lock()
+	defer r.GetInstanceLock().Unlock()
+
 	if r.GetInstance().GetConditions() == nil {
 		c := condition.Conditions{}
 		c.Init(&s.conditions)
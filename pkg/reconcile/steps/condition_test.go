@@ -1,6 +1,7 @@
 package steps
 
 import (
+	"errors"
 	"testing"
 
 	"github.com/gibizer/okofw/pkg/reconcile"
@@ -76,50 +77,91 @@ func TestStepGetName(t *testing.T) {
 
 func TestSetupCollectsConditions(t *testing.T) {
 	g := NewWithT(t)
-	step.Setup([]Step{
+	err := step.Setup([]Step{
 		NonConditionManagerStep{},
 		&step,
 		ConditionManagerStep{},
 	}, log)
 
+	g.Expect(err).NotTo(HaveOccurred())
+
 	g.Expect(step.conditions).To(HaveLen(1))
 	g.Expect(step.conditions[0].Type).To(Equal(condition.InputReadyCondition))
 }
 
 func TestSetupCollectsConditionsDedup(t *testing.T) {
 	g := NewWithT(t)
-	step.Setup([]Step{
+	err := step.Setup([]Step{
 		&step,
 		ConditionManagerStep{},
 		ConditionManagerStep{},
 	}, log)
 
+	g.Expect(err).NotTo(HaveOccurred())
+
 	g.Expect(step.conditions).To(HaveLen(1))
 }
 
 func TestSetupOrderingCheckWrongOrder(t *testing.T) {
 	g := NewWithT(t)
-	setup := func() {
-		step.Setup([]Step{
-			NonConditionManagerStep{},
-			ConditionManagerStep{},
-			&step,
-		}, log)
+	err := step.Setup([]Step{
+		NonConditionManagerStep{},
+		ConditionManagerStep{},
+		&step,
+	}, log)
+
+	g.Expect(err).To(
+		MatchError(
+			"StepOrderViolation: Step order error. Cannot add step " +
+				"ConditionManagerStep which is a ConditionManager before " +
+				"step steps.Conditions"))
+	var vErr *reconcile.ValidationError
+	g.Expect(errors.As(err, &vErr)).To(BeTrue())
+	g.Expect(vErr.Reason).To(Equal(reconcile.StepOrderViolation))
+	g.Expect(vErr.Step).To(Equal("ConditionManagerStep"))
+}
+
+type OtherConditionManagerStep struct {
+	EmptyStep
+}
+
+func (s OtherConditionManagerStep) GetName() string {
+	return "OtherConditionManagerStep"
+}
+
+func (s OtherConditionManagerStep) GetManagedConditions() condition.Conditions {
+	return []condition.Condition{
+		*condition.FalseCondition(
+			condition.InputReadyCondition,
+			condition.ErrorReason,
+			condition.SeverityError,
+			condition.InputReadyErrorMessage,
+			"wrong input",
+		),
 	}
+}
 
-	g.Expect(setup).To(
-		PanicWith(
-			"Step order error. Cannot add step ConditionManagerStep which " +
-				"is a ConditionManager before step steps.Conditions"))
+func TestSetupConditionOwnershipConflict(t *testing.T) {
+	g := NewWithT(t)
+	err := step.Setup([]Step{
+		&step,
+		ConditionManagerStep{},
+		OtherConditionManagerStep{},
+	}, log)
+
+	var vErr *reconcile.ValidationError
+	g.Expect(errors.As(err, &vErr)).To(BeTrue())
+	g.Expect(vErr.Reason).To(Equal(reconcile.ConditionOwnershipConflict))
+	g.Expect(vErr.Step).To(Equal("OtherConditionManagerStep"))
 }
 
 func TestDoInitializeConditions(t *testing.T) {
 	g := NewWithT(t)
-	step.Setup([]Step{
+	g.Expect(step.Setup([]Step{
 		NonConditionManagerStep{},
 		&step,
 		ConditionManagerStep{},
-	}, log)
+	}, log)).To(Succeed())
 
 	req := &Req{}
 	req.Instance = &Instance{}
@@ -136,11 +178,11 @@ func TestDoInitializeConditions(t *testing.T) {
 
 func TestPostCalculatesReadyCondition(t *testing.T) {
 	g := NewWithT(t)
-	step.Setup([]Step{
+	g.Expect(step.Setup([]Step{
 		NonConditionManagerStep{},
 		&step,
 		ConditionManagerStep{},
-	}, log)
+	}, log)).To(Succeed())
 
 	req := &Req{}
 	req.Instance = &Instance{}
@@ -158,3 +200,38 @@ func TestPostCalculatesReadyCondition(t *testing.T) {
 	g.Expect(conds[1].Type).To(Equal(condition.InputReadyCondition))
 	g.Expect(conds[1].Status).To(Equal(corev1.ConditionTrue))
 }
+
+func TestValidateStages(t *testing.T) {
+	g := NewWithT(t)
+	err := step.ValidateStages([][]Step{
+		{NonConditionManagerStep{}, &step},
+		{ConditionManagerStep{}},
+	})
+
+	g.Expect(err).NotTo(HaveOccurred())
+}
+
+func TestValidateStagesConditionManagerInParallelWithConditions(t *testing.T) {
+	g := NewWithT(t)
+	err := step.ValidateStages([][]Step{
+		{&step, ConditionManagerStep{}},
+	})
+
+	var vErr *reconcile.ValidationError
+	g.Expect(errors.As(err, &vErr)).To(BeTrue())
+	g.Expect(vErr.Reason).To(Equal(reconcile.StepOrderViolation))
+	g.Expect(vErr.Step).To(Equal("ConditionManagerStep"))
+}
+
+func TestValidateStagesSameConditionManagedInParallel(t *testing.T) {
+	g := NewWithT(t)
+	err := step.ValidateStages([][]Step{
+		{&step},
+		{ConditionManagerStep{}, OtherConditionManagerStep{}},
+	})
+
+	var vErr *reconcile.ValidationError
+	g.Expect(errors.As(err, &vErr)).To(BeTrue())
+	g.Expect(vErr.Reason).To(Equal(reconcile.ConditionOwnershipConflict))
+	g.Expect(vErr.Step).To(Equal("OtherConditionManagerStep"))
+}
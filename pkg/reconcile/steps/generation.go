@@ -0,0 +1,68 @@
+package steps
+
+import (
+	"github.com/gibizer/okofw/pkg/reconcile"
+	"github.com/go-logr/logr"
+	"github.com/openstack-k8s-operators/lib-common/modules/common/condition"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+type InstanceWithObservedGeneration interface {
+	client.Object
+
+	GetObservedGeneration() int64
+	SetObservedGeneration(int64)
+}
+
+// ObservedGeneration is a generic step that records in the Status of the
+// instance which generation of the Spec the Status reflects. It sets the
+// observed generation to the current generation of the instance only if
+// the Do of every step succeeded. While the observed generation is behind
+// the current one a True Ready condition is set to Unknown, if the
+// instance has conditions, so a stale Ready=True is not reported. It requires that
+// the CRD type T implements the InstanceWithObservedGeneration interface.
+// It depends on the Conditions step so it can override the Ready condition
+// calculated by it, so the Conditions step needs to be added as well.
+type ObservedGeneration[T InstanceWithObservedGeneration, R reconcile.Req[T]] struct {
+	reconcile.BaseStep[T, R]
+}
+
+func (s ObservedGeneration[T, R]) GetName() string {
+	return "ObservedGeneration"
+}
+
+// GetDependencies makes the Post of the step run after the Post of the
+// Conditions step so it can override the Ready condition calculated by it
+func (s ObservedGeneration[T, R]) GetDependencies() []reconcile.Dependency {
+	return []reconcile.Dependency{reconcile.OnStepType[ConditionsStep]()}
+}
+
+func (s ObservedGeneration[T, R]) Do(r R, log logr.Logger) reconcile.Result {
+	return r.OK()
+}
+
+func (s ObservedGeneration[T, R]) Post(r R, log logr.Logger) reconcile.Result {
+	instance := r.GetInstance()
+	if result := r.GetDoResult(); result != nil && result.IsOK() {
+		instance.SetObservedGeneration(instance.GetGeneration())
+	}
+
+	if instance.GetObservedGeneration() >= instance.GetGeneration() {
+		return r.OK()
+	}
+	obj, ok := any(instance).(InstanceWithConditions)
+	if !ok {
+		return r.OK()
+	}
+	// only a stale Ready=True is overridden, a failure reported by the
+	// other conditions is kept
+	conditions := obj.GetConditions()
+	if conditions.IsTrue(condition.ReadyCondition) {
+		conditions.MarkUnknown(
+			condition.ReadyCondition, condition.RequestedReason,
+			"Generation %d is not reconciled yet, observed generation is %d",
+			instance.GetGeneration(), instance.GetObservedGeneration())
+		obj.SetConditions(conditions)
+	}
+	return r.OK()
+}
@@ -0,0 +1,126 @@
+package steps
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/gibizer/okofw/pkg/reconcile"
+	"github.com/openstack-k8s-operators/lib-common/modules/common/condition"
+	corev1 "k8s.io/api/core/v1"
+
+	. "github.com/onsi/gomega"
+)
+
+type GenInstance struct {
+	corev1.Pod
+	Conditions         condition.Conditions
+	ObservedGeneration int64
+}
+
+func (i GenInstance) GetConditions() condition.Conditions {
+	return i.Conditions
+}
+
+func (i *GenInstance) SetConditions(conditions condition.Conditions) {
+	i.Conditions = conditions
+}
+
+func (i GenInstance) GetObservedGeneration() int64 {
+	return i.ObservedGeneration
+}
+
+func (i *GenInstance) SetObservedGeneration(generation int64) {
+	i.ObservedGeneration = generation
+}
+
+type GenReq struct {
+	reconcile.DefaultReq[*GenInstance]
+	doResult reconcile.Result
+}
+
+func (r *GenReq) GetDoResult() reconcile.Result {
+	return r.doResult
+}
+
+type GenStep = reconcile.Step[*GenInstance, *GenReq]
+
+func newGenReq(generation int64, observed int64) *GenReq {
+	instance := &GenInstance{ObservedGeneration: observed}
+	instance.Generation = generation
+	instance.Conditions = condition.Conditions{}
+	instance.Conditions.Init(nil)
+	instance.Conditions.MarkTrue(condition.ReadyCondition, condition.ReadyMessage)
+	return &GenReq{DefaultReq: reconcile.DefaultReq[*GenInstance]{Instance: instance}}
+}
+
+func TestObservedGenerationUpdatedIfDoSucceeded(t *testing.T) {
+	g := NewWithT(t)
+	step := ObservedGeneration[*GenInstance, *GenReq]{}
+	r := newGenReq(2, 1)
+	r.doResult = r.OK()
+
+	g.Expect(step.Post(r, log).IsOK()).To(BeTrue())
+
+	g.Expect(r.GetInstance().ObservedGeneration).To(Equal(int64(2)))
+	g.Expect(r.GetInstance().Conditions.IsTrue(condition.ReadyCondition)).To(BeTrue())
+}
+
+func TestObservedGenerationKeptIfDoFailed(t *testing.T) {
+	g := NewWithT(t)
+	step := ObservedGeneration[*GenInstance, *GenReq]{}
+	r := newGenReq(2, 1)
+	r.doResult = r.Error(errors.New("boom"), log)
+
+	g.Expect(step.Post(r, log).IsOK()).To(BeTrue())
+
+	g.Expect(r.GetInstance().ObservedGeneration).To(Equal(int64(1)))
+	g.Expect(r.GetInstance().Conditions.IsUnknown(condition.ReadyCondition)).To(BeTrue())
+	ready := r.GetInstance().Conditions.Get(condition.ReadyCondition)
+	g.Expect(ready.Message).To(Equal(
+		"Generation 2 is not reconciled yet, observed generation is 1"))
+}
+
+func TestObservedGenerationKeptIfDoDidNotRun(t *testing.T) {
+	g := NewWithT(t)
+	step := ObservedGeneration[*GenInstance, *GenReq]{}
+	r := newGenReq(2, 1)
+
+	g.Expect(step.Post(r, log).IsOK()).To(BeTrue())
+
+	g.Expect(r.GetInstance().ObservedGeneration).To(Equal(int64(1)))
+	g.Expect(r.GetInstance().Conditions.IsUnknown(condition.ReadyCondition)).To(BeTrue())
+}
+
+func TestObservedGenerationDependsOnConditions(t *testing.T) {
+	g := NewWithT(t)
+	conditions := &Conditions[*GenInstance, *GenReq]{}
+	generation := &ObservedGeneration[*GenInstance, *GenReq]{}
+
+	// the order of the steps is defined by the dependency
+	_, err := reconcile.NewReqHandler[*GenInstance, *GenReq]().
+		WithSteps(generation, conditions).
+		Build()
+	g.Expect(err).NotTo(HaveOccurred())
+
+	_, err = reconcile.NewReqHandler[*GenInstance, *GenReq]().
+		WithSteps(generation).
+		Build()
+	var vErr *reconcile.ValidationError
+	g.Expect(errors.As(err, &vErr)).To(BeTrue())
+	g.Expect(vErr.Reason).To(Equal(reconcile.UnknownDependency))
+	g.Expect(vErr.Step).To(Equal("ObservedGeneration"))
+}
+
+func TestObservedGenerationKeepsFailedReady(t *testing.T) {
+	g := NewWithT(t)
+	step := ObservedGeneration[*GenInstance, *GenReq]{}
+	r := newGenReq(2, 1)
+	r.GetInstance().Conditions.MarkFalse(
+		condition.ReadyCondition, condition.ErrorReason, condition.SeverityError, "boom")
+	r.doResult = r.Terminal(errors.New("boom"))
+
+	g.Expect(step.Post(r, log).IsOK()).To(BeTrue())
+
+	g.Expect(r.GetInstance().ObservedGeneration).To(Equal(int64(1)))
+	g.Expect(r.GetInstance().Conditions.IsFalse(condition.ReadyCondition)).To(BeTrue())
+}
@@ -0,0 +1,28 @@
+package reconcile
+
+import (
+	"k8s.io/apimachinery/pkg/types"
+)
+
+// terminalFailures remembers the instances whose reconciliation failed with
+// a terminal error so the steps are not run again until the generation of
+// the instance changes. The error is stored by the failed generation.
+type terminalFailures struct {
+	*instanceStore[int64, error]
+}
+
+func newTerminalFailures() *terminalFailures {
+	return &terminalFailures{newInstanceStore[int64, error]()}
+}
+
+// get returns the terminal error of the instance if it failed with the same
+// generation
+func (t *terminalFailures) get(instance types.NamespacedName, generation int64) (error, bool) {
+	return t.instanceStore.get(instance, generation)
+}
+
+// record stores the terminal error of the generation of the instance and
+// drops the errors of the other generations
+func (t *terminalFailures) record(instance types.NamespacedName, generation int64, err error) {
+	t.replace(instance, generation, err)
+}
@@ -0,0 +1,68 @@
+package reconcile
+
+import (
+	"errors"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	ctrl "sigs.k8s.io/controller-runtime"
+
+	. "github.com/onsi/gomega"
+)
+
+func TestTerminalErrorStopsUntilGenerationChanges(t *testing.T) {
+	g := NewWithT(t)
+	instance := newTestInstance()
+	instance.Generation = 1
+	c := newTestClient(instance)
+	doCalls := 0
+	handler, err := NewReqHandler[*corev1.Pod, *TestReq]().
+		WithSteps(FuncStep{name: "step1", do: func(r *TestReq) Result {
+			doCalls++
+			return r.Terminal(errors.New("division by zero"))
+		}}).
+		Build()
+	g.Expect(err).NotTo(HaveOccurred())
+
+	res, err := handler.Handle(newTestReq(c))
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(res).To(Equal(ctrl.Result{}))
+	g.Expect(doCalls).To(Equal(1))
+
+	// the same generation is not reconciled again
+	result := handler.handleReqWithReport(newTestReq(c), &Report{})
+	g.Expect(result.IsTerminal()).To(BeTrue())
+	g.Expect(result.Err()).To(MatchError("division by zero"))
+	g.Expect(doCalls).To(Equal(1))
+
+	// a new generation is reconciled again
+	g.Expect(c.Get(ctx, testInstanceName, instance)).To(Succeed())
+	instance.Generation = 2
+	g.Expect(c.Update(ctx, instance)).To(Succeed())
+	result = handler.handleReqWithReport(newTestReq(c), &Report{})
+	g.Expect(result.IsTerminal()).To(BeTrue())
+	g.Expect(doCalls).To(Equal(2))
+}
+
+func TestTerminalErrorIsReported(t *testing.T) {
+	g := NewWithT(t)
+	r := &TestReq{}
+
+	msg, reported := reconcileErrorMsg(r.Terminal(errors.New("division by zero")))
+	g.Expect(reported).To(BeTrue())
+	g.Expect(msg).To(Equal("division by zero"))
+}
+
+func TestMergeResultsTerminalOnlyIfAllErrorsAre(t *testing.T) {
+	g := NewWithT(t)
+	r := &TestReq{}
+	terminal := r.Terminal(errors.New("terminal"))
+
+	g.Expect(MergeResults(terminal, r.Requeue("wait")).IsTerminal()).To(BeTrue())
+	g.Expect(MergeResults(terminal, terminal).IsTerminal()).To(BeTrue())
+
+	merged := MergeResults(terminal, r.Error(errors.New("transient"), r.GetLog()))
+	g.Expect(merged.IsTerminal()).To(BeFalse())
+	_, err := merged.Unwrap()
+	g.Expect(err).To(HaveOccurred())
+}
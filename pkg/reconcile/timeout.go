@@ -0,0 +1,94 @@
+package reconcile
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// getTimeout returns the timeout of the step or zero if it has none
+func getTimeout[T client.Object, R Req[T]](step Step[T, R]) time.Duration {
+	if s, ok := step.(StepWithTimeout); ok {
+		return s.GetTimeout()
+	}
+	return 0
+}
+
+// getStageTimeout returns the longest timeout of the steps in the stage or
+// zero if the steps have no timeout. See validateStageTimeouts.
+func getStageTimeout[T client.Object, R Req[T]](stage []Step[T, R]) time.Duration {
+	var longest time.Duration
+	for _, step := range stage {
+		if timeout := getTimeout[T, R](step); timeout > longest {
+			longest = timeout
+		}
+	}
+	return longest
+}
+
+// validateStageTimeouts returns a ValidationError if a stage has steps both
+// with and without a timeout. The steps of a stage share the context of the
+// request so either a step would lose its timeout or a step without a
+// timeout would be limited. As the stages are formed from the dependencies
+// adding an unrelated step could silently do that.
+func validateStageTimeouts[T client.Object, R Req[T]](stages [][]Step[T, R]) error {
+	for _, stage := range stages {
+		var limited, unlimited []string
+		for _, step := range stage {
+			if getTimeout[T, R](step) > 0 {
+				limited = append(limited, step.GetName())
+			} else {
+				unlimited = append(unlimited, step.GetName())
+			}
+		}
+		if len(limited) > 0 && len(unlimited) > 0 {
+			return &ValidationError{
+				Reason: MixedStageTimeouts,
+				Step:   unlimited[0],
+				Msg: fmt.Sprintf(
+					"Steps %s without a timeout are in the same parallel "+
+						"stage as steps %s with a timeout",
+					strings.Join(unlimited, ", "), strings.Join(limited, ", ")),
+			}
+		}
+	}
+	return nil
+}
+
+// runWithTimeout runs f while the context of the request is limited by the
+// timeout. A non positive timeout means no limit. It returns true if the
+// timeout was exceeded.
+func runWithTimeout[T client.Object, R Req[T]](
+	r R, timeout time.Duration, f func(),
+) (timedOut bool) {
+	if timeout <= 0 {
+		f()
+		return false
+	}
+
+	parent := r.GetCtx()
+	ctx, cancel := context.WithTimeout(parent, timeout)
+	defer cancel()
+	setCtx(r, ctx)
+	defer setCtx(r, parent)
+
+	f()
+	// Only the deadline of our own context counts, if the parent is done
+	// then the whole reconciliation is cancelled
+	return errors.Is(ctx.Err(), context.DeadlineExceeded) && parent.Err() == nil
+}
+
+// toTimeout converts a failed result to a Timeout Result. A successful
+// result is kept as is.
+func toTimeout[T client.Object, R Req[T]](
+	r R, timeout time.Duration, result Result,
+) Result {
+	if result.IsOK() {
+		return result
+	}
+	return r.Timeout(fmt.Errorf("exceeded the timeout of %s: %s", timeout, result))
+}
@@ -0,0 +1,151 @@
+package reconcile
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/client-go/tools/record"
+
+	. "github.com/onsi/gomega"
+)
+
+type TimeoutStep struct {
+	FuncStep
+	timeout time.Duration
+}
+
+func (s TimeoutStep) GetTimeout() time.Duration {
+	return s.timeout
+}
+
+// waitForCancel blocks until the context of the request is done
+func waitForCancel(r *TestReq) Result {
+	<-r.GetCtx().Done()
+	return r.Error(r.GetCtx().Err(), r.GetLog())
+}
+
+func TestStepTimeout(t *testing.T) {
+	g := NewWithT(t)
+	c := newTestClient(newTestInstance())
+	nextRun := false
+	postCtxErr := ctx.Err()
+	handler, err := NewReqHandler[*corev1.Pod, *TestReq]().
+		WithSteps(
+			TimeoutStep{
+				FuncStep: FuncStep{
+					name: "step1",
+					do:   waitForCancel,
+					post: func(r *TestReq) Result {
+						postCtxErr = r.GetCtx().Err()
+						_, hasDeadline := r.GetCtx().Deadline()
+						g.Expect(hasDeadline).To(BeTrue())
+						return r.OK()
+					},
+				},
+				timeout: 10 * time.Millisecond,
+			},
+			FuncStep{name: "step2", do: func(r *TestReq) Result {
+				nextRun = true
+				return r.OK()
+			}},
+		).
+		Build()
+	g.Expect(err).NotTo(HaveOccurred())
+
+	req := newTestReq(c)
+	result := handler.handleReqWithReport(req, &Report{})
+	g.Expect(result.IsTimeout()).To(BeTrue())
+	g.Expect(result.IsError()).To(BeFalse())
+	g.Expect(result.IsRequeue()).To(BeTrue())
+	g.Expect(result.String()).To(ContainSubstring("exceeded the timeout of 10ms"))
+	g.Expect(nextRun).To(BeFalse())
+	g.Expect(postCtxErr).NotTo(HaveOccurred())
+	// the original context is restored after the step
+	g.Expect(req.GetCtx()).To(Equal(ctx))
+}
+
+func TestStepWithinTimeout(t *testing.T) {
+	g := NewWithT(t)
+	c := newTestClient(newTestInstance())
+	handler, err := NewReqHandler[*corev1.Pod, *TestReq]().
+		WithSteps(
+			TimeoutStep{
+				FuncStep: FuncStep{name: "step1", do: func(r *TestReq) Result {
+					return r.Requeue("not ready")
+				}},
+				timeout: time.Minute,
+			},
+		).
+		Build()
+	g.Expect(err).NotTo(HaveOccurred())
+
+	result := handler.handleReqWithReport(newTestReq(c), &Report{})
+	g.Expect(result.IsTimeout()).To(BeFalse())
+	g.Expect(result.IsRequeue()).To(BeTrue())
+}
+
+func TestParallelStageSharesLongestTimeout(t *testing.T) {
+	g := NewWithT(t)
+	c := newTestClient(newTestInstance())
+	start := time.Now()
+	handler, err := NewReqHandler[*corev1.Pod, *TestReq]().
+		WithSteps(
+			TimeoutStep{
+				FuncStep: FuncStep{name: "step1", do: waitForCancel},
+				timeout:  10 * time.Millisecond,
+			},
+			TimeoutStep{
+				FuncStep: FuncStep{name: "step2", do: waitForCancel},
+				timeout:  50 * time.Millisecond,
+			},
+		).
+		WithParallelExecution().
+		Build()
+	g.Expect(err).NotTo(HaveOccurred())
+
+	recorder := record.NewFakeRecorder(10)
+	r := newTestReq(c)
+	r.Recorder = recorder
+	report := &Report{}
+	result := handler.handleReqWithReport(r, report)
+	g.Expect(result.IsTimeout()).To(BeTrue())
+	g.Expect(result.String()).To(ContainSubstring("exceeded the timeout of 50ms"))
+	g.Expect(time.Since(start)).To(BeNumerically(">=", 50*time.Millisecond))
+
+	// the steps failed due to the timeout so they are recorded as timed out
+	// instead of failed
+	for _, name := range []string{"step1", "step2"} {
+		step, found := report.GetStep("Do", name)
+		g.Expect(found).To(BeTrue())
+		g.Expect(step.Result.IsTimeout()).To(BeTrue())
+	}
+	g.Expect(recorder.Events).To(BeEmpty())
+}
+
+func TestParallelStageMixingTimeoutsRejected(t *testing.T) {
+	g := NewWithT(t)
+	_, err := NewReqHandler[*corev1.Pod, *TestReq]().
+		WithSteps(
+			TimeoutStep{FuncStep: FuncStep{name: "step1"}, timeout: time.Second},
+			FuncStep{name: "step2"},
+			FuncStep{name: "step3", deps: []Dependency{OnStep("step1")}},
+		).
+		WithParallelExecution().
+		Build()
+
+	var vErr *ValidationError
+	g.Expect(errors.As(err, &vErr)).To(BeTrue())
+	g.Expect(vErr.Reason).To(Equal(MixedStageTimeouts))
+	g.Expect(vErr.Step).To(Equal("step2"))
+
+	// in sequential mode every step has its own stage
+	_, err = NewReqHandler[*corev1.Pod, *TestReq]().
+		WithSteps(
+			TimeoutStep{FuncStep: FuncStep{name: "step1"}, timeout: time.Second},
+			FuncStep{name: "step2"},
+		).
+		Build()
+	g.Expect(err).NotTo(HaveOccurred())
+}
@@ -0,0 +1,80 @@
+package reconcile
+
+import (
+	"context"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// TracerName is the name of the tracer the Handler creates its spans with
+const TracerName = "github.com/gibizer/okofw/pkg/reconcile"
+
+// The attributes of the spans created by the Handler
+const (
+	ControllerAttribute = attribute.Key("okofw.controller")
+	NamespaceAttribute  = attribute.Key("okofw.instance.namespace")
+	NameAttribute       = attribute.Key("okofw.instance.name")
+	GenerationAttribute = attribute.Key("okofw.instance.generation")
+	StepAttribute       = attribute.Key("okofw.step")
+	PhaseAttribute      = attribute.Key("okofw.phase")
+	OutcomeAttribute    = attribute.Key("okofw.outcome")
+	ResultAttribute     = attribute.Key("okofw.result")
+)
+
+// defaultTracer returns the tracer of the global TracerProvider. It is
+// looked up at each call so a TracerProvider set via otel.SetTracerProvider
+// after the Handler is built is also used.
+type defaultTracer struct{}
+
+func (defaultTracer) Start(
+	ctx context.Context, spanName string, opts ...trace.SpanStartOption,
+) (context.Context, trace.Span) {
+	return otel.Tracer(TracerName).Start(ctx, spanName, opts...)
+}
+
+// startReconcileSpan starts the root span of the reconciliation and sets its
+// context to the request so the spans of the phases are its children. The
+// returned function restores the original context of the request.
+func (h *Handler[T, R]) startReconcileSpan(r R) (trace.Span, func()) {
+	parent := r.GetCtx()
+	ctx, span := h.tracer.Start(
+		parent, "Reconcile",
+		trace.WithAttributes(
+			ControllerAttribute.String(h.controllerName),
+			NamespaceAttribute.String(r.GetRequest().Namespace),
+			NameAttribute.String(r.GetRequest().Name),
+		))
+	setCtx(r, ctx)
+	return span, func() { setCtx(r, parent) }
+}
+
+// startSpan starts a child span of the reconciliation. The context of the
+// request is not changed as the steps of a stage share the request while
+// they can run in parallel.
+func (h *Handler[T, R]) startSpan(
+	r R, name string, attrs ...attribute.KeyValue,
+) trace.Span {
+	_, span := h.tracer.Start(r.GetCtx(), name, trace.WithAttributes(attrs...))
+	return span
+}
+
+// endSpan records the outcome of the result on the span and ends it
+func endSpan(span trace.Span, result Result, opts ...trace.SpanEndOption) {
+	span.SetAttributes(OutcomeAttribute.String(outcome(result)))
+	if result.IsError() {
+		span.SetStatus(codes.Error, result.Err().Error())
+	}
+	span.End(opts...)
+}
+
+// endSpanWithErr ends the span and records the error if any
+func endSpanWithErr(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
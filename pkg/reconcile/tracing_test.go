@@ -0,0 +1,97 @@
+package reconcile
+
+import (
+	"errors"
+	"testing"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	corev1 "k8s.io/api/core/v1"
+
+	. "github.com/onsi/gomega"
+)
+
+func newTestTracerProvider() (*sdktrace.TracerProvider, *tracetest.InMemoryExporter) {
+	exporter := tracetest.NewInMemoryExporter()
+	return sdktrace.NewTracerProvider(sdktrace.WithSyncer(exporter)), exporter
+}
+
+func spanNames(spans tracetest.SpanStubs) []string {
+	names := []string{}
+	for _, span := range spans {
+		names = append(names, span.Name)
+	}
+	return names
+}
+
+func hasAttribute(span tracetest.SpanStub, attr attribute.KeyValue) bool {
+	for _, a := range span.Attributes {
+		if a == attr {
+			return true
+		}
+	}
+	return false
+}
+
+func TestReconcileIsTraced(t *testing.T) {
+	g := NewWithT(t)
+	instance := newTestInstance()
+	instance.Generation = 3
+	c := newTestClient(instance)
+	provider, exporter := newTestTracerProvider()
+	handler, err := NewReqHandler[*corev1.Pod, *TestReq]().
+		WithSteps(
+			FuncStep{name: "step1", do: func(r *TestReq) Result {
+				r.GetInstance().Status.Reason = "foo"
+				return r.OK()
+			}},
+			FuncStep{name: "step2", do: func(r *TestReq) Result {
+				return r.Error(errors.New("boom"), r.GetLog())
+			}},
+		).
+		WithTracerProvider(provider).
+		Build()
+	g.Expect(err).NotTo(HaveOccurred())
+
+	handler.handleReqWithReport(newTestReq(c), &Report{})
+
+	spans := exporter.GetSpans()
+	g.Expect(spanNames(spans)).To(Equal([]string{
+		"Read", "Do step1", "Do step2", "Post step1", "Post step2", "Save status",
+		"Reconcile"}))
+
+	root := spans[len(spans)-1]
+	for _, span := range spans[:len(spans)-1] {
+		g.Expect(span.Parent.SpanID()).To(Equal(root.SpanContext.SpanID()))
+	}
+	g.Expect(hasAttribute(root, NamespaceAttribute.String(testInstanceName.Namespace))).To(BeTrue())
+	g.Expect(hasAttribute(root, NameAttribute.String(testInstanceName.Name))).To(BeTrue())
+	g.Expect(hasAttribute(root, GenerationAttribute.Int64(3))).To(BeTrue())
+	g.Expect(hasAttribute(root, ControllerAttribute.String("pod"))).To(BeTrue())
+	g.Expect(hasAttribute(root, OutcomeAttribute.String(OutcomeError))).To(BeTrue())
+	g.Expect(root.Status.Code).To(Equal(codes.Error))
+
+	step2 := spans[2]
+	g.Expect(hasAttribute(step2, StepAttribute.String("step2"))).To(BeTrue())
+	g.Expect(hasAttribute(step2, PhaseAttribute.String("Do"))).To(BeTrue())
+	g.Expect(hasAttribute(step2, OutcomeAttribute.String(OutcomeError))).To(BeTrue())
+	g.Expect(step2.Status.Description).To(Equal("boom"))
+	g.Expect(spans[1].Status.Code).To(Equal(codes.Unset))
+}
+
+func TestMissingInstanceIsTraced(t *testing.T) {
+	g := NewWithT(t)
+	c := newTestClient()
+	provider, exporter := newTestTracerProvider()
+	handler, err := NewReqHandler[*corev1.Pod, *TestReq]().
+		WithSteps(FuncStep{name: "step1"}).
+		WithTracerProvider(provider).
+		Build()
+	g.Expect(err).NotTo(HaveOccurred())
+
+	g.Expect(handler.handleReqWithReport(newTestReq(c), &Report{}).IsOK()).To(BeTrue())
+
+	g.Expect(spanNames(exporter.GetSpans())).To(Equal([]string{"Read", "Reconcile"}))
+}
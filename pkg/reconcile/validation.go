@@ -0,0 +1,47 @@
+package reconcile
+
+import (
+	"fmt"
+)
+
+// ValidationReason describes why a set of steps cannot form a valid Handler
+type ValidationReason string
+
+const (
+	// DuplicateStepName means that more than one step is added with the same
+	// name
+	DuplicateStepName ValidationReason = "DuplicateStepName"
+	// StepOrderViolation means that a step is added in a position that is
+	// not allowed by another step
+	StepOrderViolation ValidationReason = "StepOrderViolation"
+	// ConditionOwnershipConflict means that multiple steps want to manage
+	// the same condition in an incompatible way
+	ConditionOwnershipConflict ValidationReason = "ConditionOwnershipConflict"
+	// UnknownDependency means that a step depends on a step that is not
+	// added
+	UnknownDependency ValidationReason = "UnknownDependency"
+	// DependencyCycle means that the dependencies between the steps form a
+	// cycle so the steps cannot be ordered
+	DependencyCycle ValidationReason = "DependencyCycle"
+	// MixedStageTimeouts means that in parallel mode a stage contains steps
+	// with and without a timeout so the stage cannot have a common timeout
+	MixedStageTimeouts ValidationReason = "MixedStageTimeouts"
+	// InvalidStepSetup means that a Step.Setup() call failed for a reason
+	// not covered by the other reasons
+	InvalidStepSetup ValidationReason = "InvalidStepSetup"
+)
+
+// ValidationError is returned by ReqHandlerBuilder.Build() if the requested
+// steps cannot form a valid Handler
+type ValidationError struct {
+	// Reason categorizes the error
+	Reason ValidationReason
+	// Step is the name of the step the error is detected at
+	Step string
+	// Msg is the human readable description of the error
+	Msg string
+}
+
+func (e *ValidationError) Error() string {
+	return fmt.Sprintf("%s: %s", e.Reason, e.Msg)
+}